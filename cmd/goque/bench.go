@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+)
+
+// The number of runs of goque bench.
+const defaultBenchRuns = 1000
+
+// Times a filter over JSON from files or stdin, loaded like RunCLI.
+// The filter is compiled once like GOQUE_JQ_FILTER, then run and its
+// result formatted -n times. With values left for input and inputs
+// it is compiled again each run, as the server does per request.
+func benchCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("bench", "[flags] [filter] [file...]", stderr)
+	runs := fs.Int("n", defaultBenchRuns, "Number of runs")
+
+	in, exitCode := loadRunInput(fs, args, stdin, stderr)
+	if in == nil {
+		return exitCode
+	}
+
+	if *runs <= 0 {
+		fmt.Fprintln(stderr, "goque: -n must be positive")
+		return exitUsage
+	}
+
+	start := time.Now()
+	code, err := in.compile(io.Discard)
+	if err != nil {
+		return runError(stderr, err, exitCompile)
+	}
+	compileTime := time.Since(start)
+
+	start = time.Now()
+	for i := 0; i < *runs; i++ {
+		if i > 0 && len(in.inputs) > 0 {
+			code, _ = in.compile(io.Discard)
+		}
+
+		out, err := in.gp.engine.Evaluate(code, in.body)
+		if h, ok := err.(*goque.HaltError); ok {
+			out = h.Value
+		} else if err != nil {
+			return runError(stderr, err, exitError)
+		}
+
+		if _, err := formatRunResult(in.gp, out); err != nil {
+			return runError(stderr, err, exitError)
+		}
+	}
+	total := time.Since(start)
+
+	fmt.Fprintf(stdout, "runs     %d\n", *runs)
+	fmt.Fprintf(stdout, "compile  %s\n", compileTime)
+	fmt.Fprintf(stdout, "total    %s\n", total)
+	fmt.Fprintf(stdout, "per run  %s\n", total/time.Duration(*runs))
+	fmt.Fprintf(stdout, "runs/s   %.0f\n", float64(*runs)/total.Seconds())
+
+	return exitOK
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/rs/zerolog/log"
+)
+
+// A goque subcommand. run is called with the arguments after the
+// command name and returns the exit code.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+}
+
+// Returns the subcommands of goque, in the order they are listed.
+func commands() []command {
+	return []command{
+		{"serve", "Start the server (the default)", serveCommand},
+		{"run", "Run a filter over JSON from files or stdin", RunCLI},
+		{"validate", "Check filters without running them", validateCommand},
+		{"bench", "Time a filter over JSON from files or stdin", benchCommand},
+		{"version", "Print the version", versionCommand},
+		{"config", "Print the effective configuration and its sources", configCommand},
+		{"help", "Print this help", helpCommand},
+	}
+}
+
+// Runs the subcommand named by the first arg with the rest, returning
+// the exit code. Without a subcommand, or if the first arg is a flag,
+// the server is started so goque and goque -p 8080 keep working.
+func RunCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serveCommand(args, stdin, stdout, stderr)
+	}
+
+	for _, c := range commands() {
+		if c.name == args[0] {
+			return c.run(args[1:], stdin, stdout, stderr)
+		}
+	}
+
+	fmt.Fprintf(stderr, "goque: unknown command %q\n", args[0])
+	printCommands(stderr)
+	return exitUsage
+}
+
+// The options with a flag in goque run and bench: those changing how a
+// filter runs over its input. Server options, e.g. -p, are unknown.
+var runFlags = []string{
+	"jq", "logLevel", "strictConfig", "escapeHtml", "bodyLimit", "maxDepth", "maxArrayLength", "maxStringLength",
+	"maxResults", "maxOutputSize", "evalTimeout", "envPrefix", "funcAllow", "funcDeny", "customFuncs",
+	"preserveNumbers", "sortKeys", "indent", "slurp", "nullInput",
+}
+
+// The options with a flag in goque validate: those changing whether a
+// filter compiles.
+var validateFlags = []string{"jq", "logLevel", "strictConfig", "funcAllow", "funcDeny", "customFuncs"}
+
+// Returns a flag set for a subcommand, printing its usage to stderr
+// on errors. Parse errors are returned, not exited on.
+func newFlagSet(name string, usage string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("goque "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: goque %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// Returns the exit code for a configuration error: 0 for -help, which
+// already printed the usage. Flag errors are printed by the flag set,
+// config file errors are written to stderr.
+func configExitCode(err error, stderr io.Writer) int {
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+
+	var fileErr *ConfigFileError
+	if errors.As(err, &fileErr) {
+		fmt.Fprintln(stderr, "goque: "+err.Error())
+	}
+
+	return exitUsage
+}
+
+// Returns the configured filter and clears it, so LoadGoqueParams
+// doesn't compile it and the subcommand can report its errors.
+func takeFilter(config map[string]*ConfigurationVar) string {
+	filter := config["jq"].val
+	config["jq"].val = ""
+
+	return filter
+}
+
+// Parses the configuration with LoadGoqueParams, writing the report
+// of a strict mode failure to stderr. Returns nil if it failed.
+func loadGoqueParams(setEnvs map[string]string, config map[string]*ConfigurationVar, stderr io.Writer) *GoqueParams {
+	gp, err := LoadGoqueParams(setEnvs, config)
+	if err != nil {
+		fmt.Fprintln(stderr, "goque: "+err.Error())
+		return nil
+	}
+
+	return gp
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: goque [command] [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(w, "\nRun goque <command> -help for the flags of a command.")
+}
+
+// Starts the server configured by the env vars and args. Only returns
+// on invalid args, the server fatals when it stops.
+func serveCommand(args []string, _ io.Reader, _ io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("serve", "[flags]", stderr)
+	fs.Usage = func() {
+		printCommands(stderr)
+		fmt.Fprintln(stderr, "\nFlags of serve:")
+		fs.PrintDefaults()
+	}
+
+	config := GetDefaultConfiguration()
+	setEnvs, _, err := parseConfiguration(fs, args, config)
+	if err != nil {
+		return configExitCode(err, stderr)
+	}
+
+	gp := loadGoqueParams(setEnvs, config, stderr)
+	if gp == nil {
+		return exitUsage
+	}
+
+	info := GetVersionInfo()
+	log.Info().
+		Str("version", info.Version).
+		Str("commit", info.Commit).
+		Str("buildTime", info.BuildTime).
+		Str("goVersion", info.GoVersion).
+		Msg("Starting goque")
+
+	PrintGoqueParams(gp)
+
+	tp := InitTracer(gp.tracerRatio, gp.tracerEndpoint)
+
+	defer func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			log.Printf("Error shutting down tracer provider: %v", err)
+		}
+	}()
+
+	RunServer(gp)
+
+	return exitOK
+}
+
+// Prints the version, see GetVersionInfo. With -json it is printed
+// like the /version endpoint.
+func versionCommand(args []string, _ io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("version", "[-json]", stderr)
+	asJSON := fs.Bool("json", false, "Print the version as JSON")
+	if err := fs.Parse(args); err != nil {
+		return configExitCode(err, stderr)
+	}
+
+	info := GetVersionInfo()
+	if !*asJSON {
+		fmt.Fprintln(stdout, info.String())
+		return exitOK
+	}
+
+	raw, err := goque.JSONAPI(false, false).Marshal(info)
+	if err != nil {
+		return runError(stderr, err, exitError)
+	}
+
+	fmt.Fprintln(stdout, string(raw))
+	return exitOK
+}
+
+// Runs a config subcommand: print (the default) or check.
+func configCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return configPrintCommand(args, stdin, stdout, stderr)
+	}
+
+	switch args[0] {
+	case "print":
+		return configPrintCommand(args[1:], stdin, stdout, stderr)
+	case "check":
+		return configCheckCommand(args[1:], stdin, stdout, stderr)
+	}
+
+	fmt.Fprintf(stderr, "goque: unknown config command %q, expected print or check\n", args[0])
+	return exitUsage
+}
+
+// Checks the configuration in strict mode, writing the report of
+// every invalid value to stderr.
+func configCheckCommand(args []string, _ io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("config check", "[flags]", stderr)
+
+	config := GetDefaultConfiguration()
+	setEnvs, _, err := parseConfiguration(fs, args, config)
+	if err != nil {
+		return configExitCode(err, stderr)
+	}
+
+	config["logLevel"].val = defaultRunLogLevel.String()
+	config["strictConfig"].val = "true"
+	if loadGoqueParams(setEnvs, config, stderr) == nil {
+		return exitUsage
+	}
+
+	fmt.Fprintln(stdout, "configuration ok")
+	return exitOK
+}
+
+// Prints the effective configuration from the config file, env vars
+// and args with the source of each value, sorted by env var.
+func configPrintCommand(args []string, _ io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("config print", "[flags]", stderr)
+
+	config := GetDefaultConfiguration()
+	_, sources, err := parseConfiguration(fs, args, config)
+	if err != nil {
+		return configExitCode(err, stderr)
+	}
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return config[names[i]].envVar < config[names[j]].envVar })
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENV VAR\tFLAG\tNAME\tVALUE\tSOURCE")
+	for _, name := range names {
+		v := config[name]
+		fmt.Fprintf(w, "%s\t-%s\t%s\t%s\t%s\n", v.envVar, v.arg, name, strconv.Quote(v.val), sources[name])
+	}
+	w.Flush()
+
+	return exitOK
+}
+
+func helpCommand(_ []string, _ io.Reader, stdout io.Writer, _ io.Writer) int {
+	printCommands(stdout)
+	return exitOK
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"run", []string{"run", ".peanuts"}, `{"peanuts":true}`, exitOK, "true\n", ""},
+		{"version", []string{"version"}, ``, exitOK, GetVersionInfo().String() + "\n", ""},
+		{"version json", []string{"version", "-json"}, ``, exitOK, `{"version":"` + GetVersionInfo().Version + `"`, ""},
+		{"unknown", []string{"pineapple"}, ``, exitUsage, "", `goque: unknown command "pineapple"`},
+		{"help", []string{"help"}, ``, exitOK, "Usage: goque [command] [flags]", ""},
+		{"flag help", []string{"version", "-help"}, ``, exitOK, "", "Usage: goque version"},
+		{"bad flag", []string{"run", "-pineapple"}, ``, exitUsage, "", "flag provided but not defined: -pineapple"},
+		{"config file error", []string{"run", "-c", "/pineapple.yaml", "."}, `1`, exitUsage, "", "goque: config file /pineapple.yaml: open /pineapple.yaml: no such file or directory\n"},
+		{"config check", []string{"config", "check"}, ``, exitOK, "configuration ok\n", ""},
+		{"config check errors", []string{"config", "check", "-md", "x"}, ``, exitUsage, "", "goque: invalid configuration:\n  -md or GOQUE_MAX_DEPTH \"x\": must be a non-negative integer\n"},
+		{"strict", []string{"run", "-sc", "true", "-md", "-1", "."}, `1`, exitUsage, "", "goque: invalid configuration:\n  -md or GOQUE_MAX_DEPTH \"-1\": must be a non-negative integer\n"},
+		{"run server flag", []string{"run", "-p", "80", "-td", "true", "."}, `1`, exitUsage, "", "flag provided but not defined: -p"},
+		{"bench server flag", []string{"bench", "-td", "true", "."}, `1`, exitUsage, "", "flag provided but not defined: -td"},
+		{"validate run flag", []string{"validate", "-sk", "true", "."}, ``, exitUsage, "", "flag provided but not defined: -sk"},
+		{"validate", []string{"validate", "-format", ".peanuts|not"}, ``, exitOK, ".peanuts | not\n", ""},
+		{"validate ast", []string{"validate", "-ast", ".peanuts"}, ``, exitOK, `{"term":{"index":{"name":"peanuts"},"type":"index"}}` + "\n", ""},
+		{"validate errors", []string{"validate", ".", ".peanuts |", "pineapple"}, ``, exitCompile, "",
+			"filter 2:1:11: filter_parse_error: unexpected EOF\nfilter 3:1:1: filter_compile_error: function not defined: pineapple/0\n"},
+		{"validate policy", []string{"validate", "-fd", "now", "now"}, ``, exitCompile, "", "filter 1:1:1: function_not_allowed"},
+		{"bench", []string{"bench", "-n", "10", ".peanuts"}, `{"peanuts":true}`, exitOK, "runs     10\n", ""},
+		{"bench runs", []string{"bench", "-n", "0", "."}, `1`, exitUsage, "", "goque: -n must be positive\n"},
+		{"bench error", []string{"bench", ".peanuts"}, `1`, exitError, "", "goque: filter_runtime_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+
+			var stdout, stderr bytes.Buffer
+			exitCode := RunCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			assert.Equal(t, tt.exitCode, exitCode)
+			assert.True(t, strings.HasPrefix(stdout.String(), tt.stdout), stdout.String())
+			assert.Contains(t, stderr.String(), tt.stderr)
+		})
+	}
+}
+
+func TestValidateCommandFiles(t *testing.T) {
+	os.Clearenv()
+
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.jq")
+	invalid := filepath.Join(dir, "invalid.jq")
+	os.WriteFile(valid, []byte(".peanuts\n| not"), 0o644)
+	os.WriteFile(invalid, []byte(".peanuts\n| )"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	exitCode := RunCommand([]string{"validate", "-f", valid, invalid}, strings.NewReader(""), &stdout, &stderr)
+
+	assert.Equal(t, exitCompile, exitCode)
+	assert.Equal(t, invalid+":2:3: filter_parse_error: unexpected token \")\"\n", stderr.String())
+}
+
+func TestConfigCommand(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("GOQUE_PORT", "1234")
+
+	var stdout, stderr bytes.Buffer
+	exitCode := RunCommand([]string{"config", "print", "-sk", "true"}, strings.NewReader(""), &stdout, &stderr)
+
+	assert.Equal(t, exitOK, exitCode)
+	assert.Regexp(t, `GOQUE_PORT +-p +port +"1234" +env\n`, stdout.String())
+	assert.Regexp(t, `GOQUE_SORT_KEYS +-sk +sortKeys +"true" +flag\n`, stdout.String())
+	assert.Regexp(t, `GOQUE_PATH +-a +path +"`+defaultPath+`" +default\n`, stdout.String())
+
+	exitCode = RunCommand([]string{"config", "pineapple"}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, exitUsage, exitCode)
+}
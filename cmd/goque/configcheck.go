@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The prefix of goque's env vars. Unknown env vars with it are
+// reported, see checkEnviron.
+const envVarPrefix = "GOQUE_"
+
+// An invalid configuration value.
+type ConfigError struct {
+	Name   string // The flag and env var, or the unknown env var
+	Value  string // The invalid value, empty for unknown env vars
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Value == "" {
+		return e.Name + ": " + e.Reason
+	}
+
+	return e.Name + " " + strconv.Quote(e.Value) + ": " + e.Reason
+}
+
+// Every invalid value of a configuration, returned by LoadGoqueParams
+// in strict mode.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  " + err.Error()
+	}
+
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+// Collects the invalid values found while parsing a configuration.
+// Outside strict mode they are warned about and replaced by their
+// default.
+type configChecker struct {
+	config map[string]*ConfigurationVar
+	strict bool
+	errs   ConfigErrors
+}
+
+// Records that the value of name is invalid for reason.
+func (c *configChecker) invalid(name string, reason string) *ConfigError {
+	v := c.config[name]
+	err := &ConfigError{Name: "-" + v.arg + " or " + v.envVar, Value: v.val, Reason: reason}
+	c.errs = append(c.errs, err)
+
+	return err
+}
+
+// Records that the value of name is invalid and replaced by def.
+func (c *configChecker) fallback(name string, reason string, def string) {
+	err := c.invalid(name, reason)
+	if !c.strict {
+		log.Warn().Msg(err.Error() + ", defaulting to `" + def + "`")
+	}
+}
+
+func (c *configChecker) parseBool(name string, def bool) bool {
+	parsed, err := strconv.ParseBool(c.config[name].val)
+	if err != nil {
+		c.fallback(name, "must be true or false", strconv.FormatBool(def))
+		return def
+	}
+
+	return parsed
+}
+
+// Parses a non-negative integer limit.
+func (c *configChecker) parseLimit(name string, def int) int {
+	parsed, err := strconv.Atoi(c.config[name].val)
+	if err != nil || parsed < 0 {
+		c.fallback(name, "must be a non-negative integer", strconv.Itoa(def))
+		return def
+	}
+
+	return parsed
+}
+
+// Parses a non-negative duration, e.g. 500ms or 10s.
+func (c *configChecker) parseDuration(name string, def time.Duration) time.Duration {
+	parsed, err := time.ParseDuration(c.config[name].val)
+	if err != nil || parsed < 0 {
+		c.fallback(name, "must be a non-negative duration, e.g. 10s", def.String())
+		return def
+	}
+
+	return parsed
+}
+
+// Records the entries of a comma separated list that are not valid.
+// The list parsers skip them with their own warning.
+func (c *configChecker) checkList(name string, valid func(string) bool, expected string) {
+	for _, entry := range strings.Split(c.config[name].val, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" && !valid(entry) {
+			c.errs = append(c.errs, &ConfigError{
+				Name:   "-" + c.config[name].arg + " or " + c.config[name].envVar,
+				Value:  entry,
+				Reason: "expected " + expected,
+			})
+		}
+	}
+}
+
+// Records the env vars starting with GOQUE_ that are not part of the
+// configuration, e.g. typos. Env vars visible to filters through
+// envPrefixes are allowed.
+func (c *configChecker) checkEnviron(envPrefixes []string) {
+	known := map[string]bool{configFileEnvVar: true}
+	for _, v := range c.config {
+		known[v.envVar] = true
+	}
+
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envVarPrefix) || known[name] || hasAnyPrefix(name, envPrefixes) {
+			continue
+		}
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+
+	for _, name := range unknown {
+		err := &ConfigError{Name: name, Reason: "unknown env var"}
+		c.errs = append(c.errs, err)
+		if !c.strict {
+			log.Warn().Msg(err.Error())
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGoqueParamsStrict(t *testing.T) {
+	environ := os.Environ()
+	defer _setEnvFromEnviron(environ)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		config  map[string]string
+		wantErr string
+	}{
+		{
+			"valid",
+			map[string]string{"GOQUE_APP_PEANUTS": "1"},
+			map[string]string{"envPrefix": "GOQUE_APP_", "tracerRatio": "0.5", "jq": ".peanuts"},
+			"",
+		},
+		{
+			"every error",
+			map[string]string{"GOQUE_PROT": "8080"},
+			map[string]string{
+				"tracerRatio":   "0,5",
+				"compress":      "yes please",
+				"maxDepth":      "-1",
+				"funcDeny":      "now, not a function",
+				"routeFuncDeny": "/public:input, /internal",
+				"haltStatus":    "0:200,5:999",
+				"jq":            ".peanuts |",
+			},
+			"invalid configuration:\n" +
+				"  -tr or GOQUE_TRACER_RATIO \"0,5\": must be a number from 0 to 1\n" +
+				"  -z or GOQUE_COMPRESS \"yes please\": must be true or false\n" +
+				"  -md or GOQUE_MAX_DEPTH \"-1\": must be a non-negative integer\n" +
+				"  -fd or GOQUE_JQ_FUNC_DENY \"not a function\": expected name, name/arity or @format\n" +
+				"  -rd or GOQUE_ROUTE_FUNC_DENY \"/internal\": expected path:name, name, name/arity or @format\n" +
+				"  -hs or GOQUE_JQ_HALT_STATUS \"5:999\": expected exitcode:status\n" +
+				"  GOQUE_PROT: unknown env var\n" +
+				"  -jq or GOQUE_JQ_FILTER \".peanuts |\": unexpected EOF",
+		},
+		{
+			"out of range tracer ratio",
+			nil,
+			map[string]string{"tracerRatio": "1.5"},
+			"invalid configuration:\n  -tr or GOQUE_TRACER_RATIO \"1.5\": must be a number from 0 to 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			config := GetDefaultConfiguration()
+			for k, v := range tt.config {
+				config[k].val = v
+			}
+			config["strictConfig"].val = "true"
+
+			gp, err := LoadGoqueParams(map[string]string{}, config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, gp.engine.Config().Filter)
+				return
+			}
+
+			assert.Nil(t, gp)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadGoqueParamsLenient(t *testing.T) {
+	environ := os.Environ()
+	defer _setEnvFromEnviron(environ)
+	os.Clearenv()
+	os.Setenv("GOQUE_PROT", "8080")
+
+	config := GetDefaultConfiguration()
+	config["tracerRatio"].val = "0,5"
+	config["maxDepth"].val = "-1"
+
+	gp, err := LoadGoqueParams(map[string]string{}, config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, defaultTracerRatio, gp.tracerRatio)
+	assert.Equal(t, defaultMaxDepth, gp.engine.Config().MaxDepth)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The env var naming the config file, also set with -c.
+const configFileEnvVar = "GOQUE_CONFIG"
+
+// Where a configuration value came from, in order of precedence.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// An invalid or unreadable config file.
+type ConfigFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigFileError) Error() string {
+	return "config file " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigFileError) Unwrap() error {
+	return e.Err
+}
+
+// Sets config from a YAML, JSON or TOML file, chosen by extension.
+// Keys are the configuration names, e.g. maxDepth. Nested tables are
+// joined into a name, so tracer: {ratio: 0.5} sets tracerRatio.
+// Lists are joined with commas, maps of a single option with colons,
+// e.g. haltStatus: {0: 200}. Returns the names that were set.
+func loadConfigFile(path string, config map[string]*ConfigurationVar) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &ConfigFileError{path, err}
+	}
+
+	var values map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		err = fmt.Errorf("unknown format %q, expected .yaml, .yml, .json or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, &ConfigFileError{path, err}
+	}
+
+	var set []string
+	if err := setConfigValues("", values, config, &set); err != nil {
+		return nil, &ConfigFileError{path, err}
+	}
+
+	return set, nil
+}
+
+func setConfigValues(prefix string, values map[string]any, config map[string]*ConfigurationVar, set *[]string) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == "" {
+			if prefix == "" {
+				return fmt.Errorf("empty option name")
+			}
+			return fmt.Errorf("empty option name in %q", prefix)
+		}
+
+		name := k
+		if prefix != "" {
+			name = prefix + strings.ToUpper(k[:1]) + k[1:]
+		}
+
+		v, ok := config[name]
+		if nested, isMap := values[k].(map[string]any); isMap && !ok {
+			if err := setConfigValues(name, nested, config, set); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !ok {
+			return fmt.Errorf("unknown option %q", name)
+		}
+
+		val, err := configValueString(values[k])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		v.val = val
+		*set = append(*set, name)
+	}
+
+	return nil
+}
+
+// Formats a decoded config file value as a configuration string.
+func configValueString(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[any]any:
+		// YAML maps with non-string keys, e.g. haltStatus: {0: 200}
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = item
+		}
+		return configValueString(m)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			s, err := configValueString(v[k])
+			if err != nil {
+				return "", err
+			}
+			items[i] = k + ":" + s
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		err     string
+	}{
+		{
+			"yaml",
+			"goque.yaml",
+			"port: 9090\ntracer:\n  ratio: 0.5\nhaltStatus: {0: 200, 5: 400}\nfuncDeny: [now, env]\nescapeHtml: true\n",
+			map[string]string{"port": "9090", "tracerRatio": "0.5", "haltStatus": "0:200,5:400", "funcDeny": "now,env", "escapeHtml": "true"},
+			"",
+		},
+		{
+			"json",
+			"goque.json",
+			`{"jq": ".peanuts", "maxDepth": 64, "tracer": {"disable": true}}`,
+			map[string]string{"jq": ".peanuts", "maxDepth": "64", "tracerDisable": "true"},
+			"",
+		},
+		{
+			"toml",
+			"goque.toml",
+			"bodyLimit = 1024\nfilterAllowlist = [\"pineapple\"]\n\n[tracer]\nendpoint = \"http://tracer:14268\"\n",
+			map[string]string{"bodyLimit": "1024", "filterAllowlist": "pineapple", "tracerEndpoint": "http://tracer:14268"},
+			"",
+		},
+		{"unknown option", "goque.yaml", "pineapple: true\n", nil, `unknown option "pineapple"`},
+		{"unknown nested option", "goque.yaml", "tracer:\n  peanuts: 1\n", nil, `unknown option "tracerPeanuts"`},
+		{"empty option", "goque.json", `{"": 1}`, nil, `empty option name`},
+		{"empty nested option", "goque.json", `{"tracer": {"": 1}}`, nil, `empty option name in "tracer"`},
+		{"unknown format", "goque.ini", "port=1\n", nil, `unknown format ".ini"`},
+		{"invalid", "goque.json", `{"port":`, nil, "goque.json: unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			os.WriteFile(path, []byte(tt.content), 0o644)
+
+			config := GetDefaultConfiguration()
+			set, err := loadConfigFile(path, config)
+
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, set, len(tt.want))
+			for name, val := range tt.want {
+				assert.Equal(t, val, config[name].val, name)
+			}
+		})
+	}
+}
+
+func TestParseConfigurationPrecedence(t *testing.T) {
+	environ := os.Environ()
+	defer _setEnvFromEnviron(environ)
+	os.Clearenv()
+
+	path := filepath.Join(t.TempDir(), "goque.yaml")
+	os.WriteFile(path, []byte("port: 1\nhost: file\npath: /file\n"), 0o644)
+
+	os.Setenv("GOQUE_CONFIG", path)
+	os.Setenv("GOQUE_PORT", "2")
+	os.Setenv("GOQUE_HOST", "env")
+
+	config := GetDefaultConfiguration()
+	setEnvs, sources, err := parseConfiguration(flag.NewFlagSet("", flag.ContinueOnError), []string{"-p", "3"}, config)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"GOQUE_PORT": "2", "GOQUE_HOST": "env"}, setEnvs)
+
+	assert.Equal(t, "3", config["port"].val)
+	assert.Equal(t, "env", config["host"].val)
+	assert.Equal(t, "/file", config["path"].val)
+	assert.Equal(t, strconv.Itoa(defaultDecompressLimit), config["decompressLimit"].val)
+
+	assert.Equal(t, sourceFlag, sources["port"])
+	assert.Equal(t, sourceEnv, sources["host"])
+	assert.Equal(t, sourceFile, sources["path"])
+	assert.Equal(t, sourceDefault, sources["decompressLimit"])
+
+	// -c overrides GOQUE_CONFIG
+	_, _, err = parseConfiguration(flag.NewFlagSet("", flag.ContinueOnError), []string{"-c", path + ".missing"}, GetDefaultConfiguration())
+	assert.ErrorContains(t, err, "config file "+path+".missing")
+}
@@ -1,49 +1,148 @@
 /*
 Goque is a high throughput HTTP JQ processor based on fiber and gojq.
 Goque is highly configurable and made for both container and local usage.
+Values are taken from the config file, then env vars (GOQUE_ and the
+Env Var below), then flags, then headers, see parseConfiguration.
+Goque runs the server by default. The commands serve, run, validate,
+bench, version and config are listed by goque help, see RunCommand.
 
 Configuration of goque:
 
-| Description           | Default        | Env Var     | CLI  | HTTP Header       |
-| :-------------------- | :------------- | :---------- | :--- | :---------------- |
-| JQ filter string      |                | JQ_FILTER   | -jq  | x-goque-jq-filter |
-| JQ API path           | `"/api/v1/jq"` | JQ_PATH     | -a   |                   |
-| Server host           | `""`           | HOST        | -h   |                   |
-| Server port           | `"8080"`       | PORT        | -p   |                   |
-| Escape HTML on return | `false`        | HTML_ESCAPE | -e   |                   |
+| Description           | Default           | Env Var           | CLI | HTTP Header        |
+| :-------------------- | :---------------- | :---------------- | :-- | :----------------- |
+| JQ filter string      |                   | JQ_FILTER         | -jq | x-goque-jq-filter  |
+| JQ API path           | `"/api/v1/jq"`    | JQ_PATH           | -a  |                    |
+| OpenAPI document path | `"/openapi.json"` | OAS_PATH          | -op |                    |
+| Playground UI path    |                   | PLAYGROUND_PATH   | -pg |                    |
+| Server host           | `""`              | HOST              | -h  |                    |
+| Server port           | `"8080"`          | PORT              | -p  |                    |
+| Escape HTML on return | `false`           | HTML_ESCAPE       | -e  |                    |
+| Compress responses    | `true`            | COMPRESS          | -z  |                    |
+| Max decompressed body | `10485760`        | DECOMPRESS_LIMIT  | -dl |                    |
+| Max body size         | `4194304`         | BODY_LIMIT        | -bl |                    |
+| Max nesting depth     | `512`             | MAX_DEPTH         | -md |                    |
+| Max array length      | `0`               | MAX_ARRAY_LENGTH  | -ma |                    |
+| Max string length     | `0`               | MAX_STRING_LENGTH | -ms |                    |
+| Max results           | `0`               | MAX_RESULTS       | -mr |                    |
+| Max response size     | `0`               | MAX_OUTPUT_SIZE   | -mo |                    |
+| Max evaluation time   | `10s`             | EVAL_TIMEOUT      | -et |                    |
+| Header filter policy  | `allow`           | FILTER_POLICY     | -fp |                    |
+| Header filter hashes  |                   | FILTER_ALLOWLIST  | -fa |                    |
+| Named filter dir      |                   | FILTER_DIR        | -fn |                    |
+| Route filter policies |                   | ROUTE_POLICY      | -rp |                    |
+| Header filter cache   | `256`             | FILTER_CACHE_SIZE | -fc |                    |
+| Filter env prefixes   |                   | JQ_ENV_PREFIX     | -ep |                    |
+| Allowed functions     |                   | JQ_FUNC_ALLOW     | -fw |                    |
+| Denied functions      |                   | JQ_FUNC_DENY      | -fd |                    |
+| Route allowed funcs   |                   | ROUTE_FUNC_ALLOW  | -rw |                    |
+| Route denied funcs    |                   | ROUTE_FUNC_DENY   | -rd |                    |
+| Custom functions      | `all`             | JQ_CUSTOM_FUNCS   | -cf |                    |
+| Halt exit code status | `0:200,5:422`     | JQ_HALT_STATUS    | -hs |                    |
+| Allow debug requests  | `false`           | DEBUG_MODE        | -dm | x-goque-debug      |
+| Preserve big numbers  | `true`            | PRESERVE_NUMBERS  | -pn |                    |
+| Sort object keys      | `false`           | SORT_KEYS         | -sk | x-goque-sort-keys  |
+| Indent, 0-7 or tab    | `0`               | INDENT            | -in | x-goque-indent     |
+| Slurp body values     | `false`           | SLURP             | -sl | x-goque-slurp      |
+| Null input            | `false`           | NULL_INPUT        | -ni | x-goque-null-input |
+| Route input modes     |                   | ROUTE_INPUT       | -ri |                    |
+| Config file           |                   | CONFIG            | -c  |                    |
+| Strict configuration  | `false`           | STRICT_CONFIG     | -sc |                    |
 
 Usage of ./goque:
   -a string
         Server path (default "/api/v1/jq")
+  -bl string
+        Max request body size, bytes (default "4194304")
+  -c string
+        Config file, YAML, JSON or TOML
+  -cf string
+        Custom functions to enable, comma separated, all or none (default "all")
+  -dl string
+        Max decompressed request body size, bytes (default "10485760")
+  -dm string
+        Allow x-goque-debug requests (default "false")
   -e string
         Escape HTML on return (default "false")
+  -ep string
+        Env var prefixes visible to filters, comma separated
+  -et string
+        Max filter evaluation time, e.g. 500ms, 0 is unlimited (default "10s")
+  -fa string
+        Allowlisted header filter SHA-256 hashes or names, comma separated
+  -fc string
+        Max compiled header filters cached, 0 disables the cache (default "256")
+  -fd string
+        Functions filters may not call, comma separated
+  -fn string
+        Directory of named filters, one name.jq file each
+  -fp string
+        Header filter policy: allow, deny or allowlist (default "allow")
+  -fw string
+        Functions filters may call, comma separated, empty allows all
   -h string
         Server host
+  -hs string
+        HTTP status for halt exit codes, exitcode:status, comma separated (default "0:200,5:422")
+  -in string
+        Indent of JSON results, 0 (compact) to 7 spaces or tab (default "0")
   -jq string
         JQ filter string
   -l string
         Default log level (default "Info")
+  -ma string
+        Max body array length, 0 is unlimited (default "0")
+  -md string
+        Max body nesting depth, 0 is unlimited (default "512")
+  -mo string
+        Max response size, bytes, 0 is unlimited (default "0")
+  -mr string
+        Max results emitted by a filter, 0 is unlimited (default "0")
+  -ms string
+        Max body string length, 0 is unlimited (default "0")
+  -ni string
+        Run filters against null, the body is optional (default "false")
+  -op string
+        OpenAPI document path, empty disables it (default "/openapi.json")
   -p string
         Server port (default "8080")
+  -pg string
+        Playground UI path, empty disables it
+  -pn string
+        Decode numbers without losing integer precision (default "true")
+  -rd string
+        Functions filters may not call by route path, path:name then names, comma separated
+  -rp string
+        Header filter policy by route path, path:policy, comma separated
+  -ri string
+        Input modes by route path, path:mode then modes, slurp or null, comma separated
+  -rw string
+        Functions filters may call by route path, path:name then names, comma separated
   -s string
         Server scheme
+  -sc string
+        Refuse to start on any invalid configuration (default "false")
+  -sk string
+        Sort object keys of JSON results (default "false")
+  -sl string
+        Combine all JSON values in the body into an array (default "false")
   -td string
         Disable tracer (default "false")
   -te string
         Tracer endpoint, url (default "http://localhost:14268/api/traces")
   -tr string
         Tracer ratio, 0-1 (default "1")
+  -z string
+        Compress responses (default "true")
 */
 
 package main
 
 import (
-	"context"
 	"flag"
 	"os"
 	"strconv"
 
-	"github.com/itchyny/gojq"
+	"github.com/Max-Clark/goque/pkg/goque"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -57,44 +156,89 @@ const defaultScheme = ""
 const defaultTracerDisable = false
 const defaultTracerRatio = 1.0
 const defaultTracerEndpoint = "http://localhost:14268/api/traces"
+const defaultCompress = true
+const defaultDecompressLimit = goque.DefaultDecompressLimit
+const defaultBodyLimit = goque.DefaultBodyLimit
+const defaultMaxDepth = goque.DefaultMaxDepth
+const defaultMaxArrayLength = 0
+const defaultMaxStringLength = 0
+const defaultMaxResults = 0
+const defaultMaxOutputSize = 0
+const defaultEvalTimeout = goque.DefaultEvalTimeout
+const defaultHeaderFilterPolicy = goque.FilterPolicyAllow
+const defaultFilterCacheSize = goque.DefaultCacheSize
+const defaultCustomFuncs = "all"
+const defaultHaltStatus = "0:200,5:422"
+const defaultDebugMode = false
+const defaultPreserveNumbers = true
+const defaultSortKeys = false
+const defaultIndent = "0"
+const defaultSlurp = false
+const defaultNullInput = false
+const defaultStrictConfig = false
+const defaultOASPath = "/openapi.json"
+const defaultPlaygroundPath = ""
 
-// Entry to goque. Initializes logger, gets params, and
-// starts server
+// Entry to goque. Runs the subcommand in the args, starting the
+// server if there is none, see RunCommand.
 func main() {
-	setEnvs, config := SetConfiguration(GetDefaultConfiguration())
-
-	gp := ParseGoqueParams(setEnvs, config)
-
-	PrintGoqueParams(gp)
-
-	tp := InitTracer(gp.tracerRatio, gp.tracerEndpoint)
-
-	defer func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Printf("Error shutting down tracer provider: %v", err)
-		}
-	}()
-
-	RunServer(gp)
+	os.Exit(RunCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
 // Returns the default configuration values in a map of ConfigurationVars.
 func GetDefaultConfiguration() map[string]*ConfigurationVar {
 	return map[string]*ConfigurationVar{
-		"jq":             {desc: "JQ filter string", val: "", envVar: "GOQUE_JQ_FILTER", arg: "jq"},
-		"path":           {desc: "Server path", val: defaultPath, envVar: "GOQUE_PATH", arg: "a"},
-		"host":           {desc: "Server host", val: defaultHost, envVar: "GOQUE_HOST", arg: "h"},
-		"port":           {desc: "Server port", val: defaultPort, envVar: "GOQUE_PORT", arg: "p"},
-		"scheme":         {desc: "Server scheme", val: defaultScheme, envVar: "GOQUE_SCHEME", arg: "s"},
-		"escapeHtml":     {desc: "Escape HTML on return", val: strconv.FormatBool(defaultEscapeHTML), envVar: "GOQUE_HTML_ESCAPE", arg: "e"},
-		"logLevel":       {desc: "Default log level", val: defaultLogLevel.String(), envVar: "GOQUE_LOG_LEVEL", arg: "l"},
-		"tracerDisable":  {desc: "Disable tracer", val: strconv.FormatBool(defaultTracerDisable), envVar: "GOQUE_TRACER_DISABLE", arg: "td"},
-		"tracerRatio":    {desc: "Tracer ratio, 0-1", val: strconv.FormatFloat(defaultTracerRatio, 'f', -1, 64), envVar: "GOQUE_TRACER_RATIO", arg: "tr"},
-		"tracerEndpoint": {desc: "Tracer endpoint, url", val: defaultTracerEndpoint, envVar: "GOQUE_TRACER_ENDPOINT", arg: "te"},
+		"jq":              {desc: "JQ filter string", val: "", envVar: "GOQUE_JQ_FILTER", arg: "jq"},
+		"path":            {desc: "Server path", val: defaultPath, envVar: "GOQUE_PATH", arg: "a"},
+		"host":            {desc: "Server host", val: defaultHost, envVar: "GOQUE_HOST", arg: "h"},
+		"port":            {desc: "Server port", val: defaultPort, envVar: "GOQUE_PORT", arg: "p"},
+		"scheme":          {desc: "Server scheme", val: defaultScheme, envVar: "GOQUE_SCHEME", arg: "s"},
+		"escapeHtml":      {desc: "Escape HTML on return", val: strconv.FormatBool(defaultEscapeHTML), envVar: "GOQUE_HTML_ESCAPE", arg: "e"},
+		"logLevel":        {desc: "Default log level", val: defaultLogLevel.String(), envVar: "GOQUE_LOG_LEVEL", arg: "l"},
+		"tracerDisable":   {desc: "Disable tracer", val: strconv.FormatBool(defaultTracerDisable), envVar: "GOQUE_TRACER_DISABLE", arg: "td"},
+		"tracerRatio":     {desc: "Tracer ratio, 0-1", val: strconv.FormatFloat(defaultTracerRatio, 'f', -1, 64), envVar: "GOQUE_TRACER_RATIO", arg: "tr"},
+		"tracerEndpoint":  {desc: "Tracer endpoint, url", val: defaultTracerEndpoint, envVar: "GOQUE_TRACER_ENDPOINT", arg: "te"},
+		"compress":        {desc: "Compress responses", val: strconv.FormatBool(defaultCompress), envVar: "GOQUE_COMPRESS", arg: "z"},
+		"decompressLimit": {desc: "Max decompressed request body size, bytes", val: strconv.Itoa(defaultDecompressLimit), envVar: "GOQUE_DECOMPRESS_LIMIT", arg: "dl"},
+		"bodyLimit":       {desc: "Max request body size, bytes", val: strconv.Itoa(defaultBodyLimit), envVar: "GOQUE_BODY_LIMIT", arg: "bl"},
+		"maxDepth":        {desc: "Max body nesting depth, 0 is unlimited", val: strconv.Itoa(defaultMaxDepth), envVar: "GOQUE_MAX_DEPTH", arg: "md"},
+		"maxArrayLength":  {desc: "Max body array length, 0 is unlimited", val: strconv.Itoa(defaultMaxArrayLength), envVar: "GOQUE_MAX_ARRAY_LENGTH", arg: "ma"},
+		"maxStringLength": {desc: "Max body string length, 0 is unlimited", val: strconv.Itoa(defaultMaxStringLength), envVar: "GOQUE_MAX_STRING_LENGTH", arg: "ms"},
+		"maxResults":      {desc: "Max results emitted by a filter, 0 is unlimited", val: strconv.Itoa(defaultMaxResults), envVar: "GOQUE_MAX_RESULTS", arg: "mr"},
+		"maxOutputSize":   {desc: "Max response size, bytes, 0 is unlimited", val: strconv.Itoa(defaultMaxOutputSize), envVar: "GOQUE_MAX_OUTPUT_SIZE", arg: "mo"},
+		"evalTimeout":     {desc: "Max filter evaluation time, e.g. 500ms, 0 is unlimited", val: defaultEvalTimeout.String(), envVar: "GOQUE_EVAL_TIMEOUT", arg: "et"},
+		"filterPolicy":    {desc: "Header filter policy: allow, deny or allowlist", val: string(defaultHeaderFilterPolicy), envVar: "GOQUE_FILTER_POLICY", arg: "fp"},
+		"filterAllowlist": {desc: "Allowlisted header filter SHA-256 hashes or names, comma separated", val: "", envVar: "GOQUE_FILTER_ALLOWLIST", arg: "fa"},
+		"filterDir":       {desc: "Directory of named filters, one name.jq file each", val: "", envVar: "GOQUE_FILTER_DIR", arg: "fn"},
+		"routePolicy":     {desc: "Header filter policy by route path, path:policy, comma separated", val: "", envVar: "GOQUE_ROUTE_POLICY", arg: "rp"},
+		"filterCacheSize": {desc: "Max compiled header filters cached, 0 disables the cache", val: strconv.Itoa(defaultFilterCacheSize), envVar: "GOQUE_FILTER_CACHE_SIZE", arg: "fc"},
+		"envPrefix":       {desc: "Env var prefixes visible to filters, comma separated", val: "", envVar: "GOQUE_JQ_ENV_PREFIX", arg: "ep"},
+		"funcAllow":       {desc: "Functions filters may call, comma separated, empty allows all", val: "", envVar: "GOQUE_JQ_FUNC_ALLOW", arg: "fw"},
+		"funcDeny":        {desc: "Functions filters may not call, comma separated", val: "", envVar: "GOQUE_JQ_FUNC_DENY", arg: "fd"},
+		"routeFuncAllow":  {desc: "Functions filters may call by route path, path:name then names, comma separated", val: "", envVar: "GOQUE_ROUTE_FUNC_ALLOW", arg: "rw"},
+		"routeFuncDeny":   {desc: "Functions filters may not call by route path, path:name then names, comma separated", val: "", envVar: "GOQUE_ROUTE_FUNC_DENY", arg: "rd"},
+		"customFuncs":     {desc: "Custom functions to enable, comma separated, all or none", val: defaultCustomFuncs, envVar: "GOQUE_JQ_CUSTOM_FUNCS", arg: "cf"},
+		"haltStatus":      {desc: "HTTP status for halt exit codes, exitcode:status, comma separated", val: defaultHaltStatus, envVar: "GOQUE_JQ_HALT_STATUS", arg: "hs"},
+		"debugMode":       {desc: "Allow x-goque-debug requests", val: strconv.FormatBool(defaultDebugMode), envVar: "GOQUE_DEBUG_MODE", arg: "dm"},
+		"preserveNumbers": {desc: "Decode numbers without losing integer precision", val: strconv.FormatBool(defaultPreserveNumbers), envVar: "GOQUE_PRESERVE_NUMBERS", arg: "pn"},
+		"sortKeys":        {desc: "Sort object keys of JSON results", val: strconv.FormatBool(defaultSortKeys), envVar: "GOQUE_SORT_KEYS", arg: "sk"},
+		"indent":          {desc: "Indent of JSON results, 0 (compact) to 7 spaces or tab", val: defaultIndent, envVar: "GOQUE_INDENT", arg: "in"},
+		"slurp":           {desc: "Combine all JSON values in the body into an array", val: strconv.FormatBool(defaultSlurp), envVar: "GOQUE_SLURP", arg: "sl"},
+		"nullInput":       {desc: "Run filters against null, the body is optional", val: strconv.FormatBool(defaultNullInput), envVar: "GOQUE_NULL_INPUT", arg: "ni"},
+		"routeInput":      {desc: "Input modes by route path, path:mode then modes, slurp or null, comma separated", val: "", envVar: "GOQUE_ROUTE_INPUT", arg: "ri"},
+		"oasPath":         {desc: "OpenAPI document path, empty disables it", val: defaultOASPath, envVar: "GOQUE_OAS_PATH", arg: "op"},
+		"playgroundPath":  {desc: "Playground UI path, empty disables it", val: defaultPlaygroundPath, envVar: "GOQUE_PLAYGROUND_PATH", arg: "pg"},
+		"strictConfig":    {desc: "Refuse to start on any invalid configuration", val: strconv.FormatBool(defaultStrictConfig), envVar: "GOQUE_STRICT_CONFIG", arg: "sc"},
 	}
 }
 
-func ParseGoqueParams(setEnvs map[string]string, config map[string]*ConfigurationVar) *GoqueParams {
+// Parses the configuration into GoqueParams. Invalid values are
+// replaced by their default with a warning, unless GOQUE_STRICT_CONFIG
+// is set: then every invalid value, unknown GOQUE_ env var and error
+// in the filter is returned as ConfigErrors.
+func LoadGoqueParams(setEnvs map[string]string, config map[string]*ConfigurationVar) (*GoqueParams, error) {
+	c := &configChecker{config: config}
+
 	// Parse logLevel, use default if error
 	parsedLogLevel, err := zerolog.ParseLevel(config["logLevel"].val)
 	if err != nil {
@@ -103,84 +247,273 @@ func ParseGoqueParams(setEnvs map[string]string, config map[string]*Configuratio
 
 	InitLogging(parsedLogLevel)
 
+	// Parse strictConfig first, it decides how errors are reported
+	c.strict = c.parseBool("strictConfig", defaultStrictConfig)
+
 	if err != nil {
-		log.Warn().AnErr("InitTracer", err).Msg("Invalid logLevel, defaulting to " + defaultLogLevel.String())
+		c.fallback("logLevel", "must be a log level, e.g. info", defaultLogLevel.String())
 	}
 
 	log.Debug().Interface("setEnvs", setEnvs).Msg("")
 
-	// Parse tracerDisable, use default if error
-	parsedEscapeHtml, err := strconv.ParseBool(config["escapeHtml"].val)
-	if err != nil {
-		log.Warn().Msg("-e or HTML_ESCAPE invalid, defaulting to `false`")
-		parsedEscapeHtml = defaultEscapeHTML
+	parsedEscapeHtml := c.parseBool("escapeHtml", defaultEscapeHTML)
+	parsedTracerDisable := c.parseBool("tracerDisable", defaultTracerDisable)
+
+	// Parse tracerRatio, use default if error
+	parsedTracerRatio, err := strconv.ParseFloat(config["tracerRatio"].val, 64)
+	if err != nil || parsedTracerRatio < 0 || parsedTracerRatio > 1 {
+		c.fallback("tracerRatio", "must be a number from 0 to 1", strconv.FormatFloat(defaultTracerRatio, 'f', -1, 64))
+		parsedTracerRatio = defaultTracerRatio
 	}
 
-	// Parse tracerDisable, use default if error
-	parsedTracerDisable, err := strconv.ParseBool(config["tracerDisable"].val)
-	if err != nil {
-		log.Warn().Msg("-td or GOQUE_TRACER_DISABLE invalid, defaulting to `false`")
-		parsedTracerDisable = defaultTracerDisable
+	parsedCompress := c.parseBool("compress", defaultCompress)
+
+	// Parse decompressLimit, use default if error
+	parsedDecompressLimit, err := strconv.ParseInt(config["decompressLimit"].val, 10, 64)
+	if err != nil || parsedDecompressLimit <= 0 {
+		c.fallback("decompressLimit", "must be a positive integer", strconv.Itoa(defaultDecompressLimit))
+		parsedDecompressLimit = defaultDecompressLimit
 	}
 
-	// Parse tracerDisable, use default if error
-	parsedTracerRatio, err := strconv.ParseFloat(config["tracerRatio"].val, 64)
+	// Parse body limits, use defaults if error
+	parsedBodyLimit := c.parseLimit("bodyLimit", defaultBodyLimit)
+	parsedMaxDepth := c.parseLimit("maxDepth", defaultMaxDepth)
+	parsedMaxArrayLength := c.parseLimit("maxArrayLength", defaultMaxArrayLength)
+	parsedMaxStringLength := c.parseLimit("maxStringLength", defaultMaxStringLength)
+
+	// Parse output limits, use defaults if error
+	parsedMaxResults := c.parseLimit("maxResults", defaultMaxResults)
+	parsedMaxOutputSize := c.parseLimit("maxOutputSize", defaultMaxOutputSize)
+	parsedEvalTimeout := c.parseDuration("evalTimeout", defaultEvalTimeout)
+
+	// Parse filterPolicy, use default if error
+	parsedFilterPolicy, ok := goque.ParseFilterPolicy(config["filterPolicy"].val)
+	if !ok {
+		c.fallback("filterPolicy", "must be allow, deny or allowlist", string(defaultHeaderFilterPolicy))
+		parsedFilterPolicy = defaultHeaderFilterPolicy
+	}
+
+	parsedFilterAllowlist := goque.ParseFilterAllowlist(config["filterAllowlist"].val)
+	if parsedFilterPolicy == goque.FilterPolicyAllowlist && len(parsedFilterAllowlist) == 0 {
+		log.Warn().Msg("GOQUE_FILTER_POLICY is `allowlist` but GOQUE_FILTER_ALLOWLIST is empty, all header filters will be rejected")
+	}
+
+	parsedFilterCacheSize := c.parseLimit("filterCacheSize", defaultFilterCacheSize)
+
+	// Load the named filters, none if the directory can't be read
+	parsedNamedFilters, err := goque.LoadNamedFilters(config["filterDir"].val)
 	if err != nil {
-		log.Warn().AnErr("InitTracer", err).Msg("Invalid tracerRatio, defaulting to `1` (100%)")
-		parsedTracerRatio = defaultTracerRatio
+		c.fallback("filterDir", err.Error(), "")
+	}
+
+	parsedDebugMode := c.parseBool("debugMode", defaultDebugMode)
+	parsedPreserveNumbers := c.parseBool("preserveNumbers", defaultPreserveNumbers)
+
+	// Parse sortKeys and indent, use defaults if error
+	parsedSortKeys := c.parseBool("sortKeys", defaultSortKeys)
+
+	parsedIndent, ok := goque.ParseIndent(config["indent"].val)
+	if !ok {
+		c.fallback("indent", "must be 0-"+strconv.Itoa(goque.MaxIndent)+" or tab", defaultIndent)
+		parsedIndent, _ = goque.ParseIndent(defaultIndent)
+	}
+
+	// Parse input modes, use defaults if error
+	parsedSlurp := c.parseBool("slurp", defaultSlurp)
+	parsedNullInput := c.parseBool("nullInput", defaultNullInput)
+
+	// Lists skip invalid entries with a warning, record them for
+	// strict mode
+	c.checkList("filterAllowlist", goque.IsFilterAllowlistEntry, "a SHA-256 hex hash or filter name")
+	c.checkList("routePolicy", func(entry string) bool {
+		_, _, ok := goque.ParseRoutePolicyEntry(entry)
+		return ok
+	}, "path:policy")
+	c.checkList("funcAllow", goque.IsFuncListEntry, "name, name/arity or @format")
+	c.checkList("funcDeny", goque.IsFuncListEntry, "name, name/arity or @format")
+	c.checkList("routeFuncAllow", goque.IsRouteFuncListEntry, "path:name, name, name/arity or @format")
+	c.checkList("routeFuncDeny", goque.IsRouteFuncListEntry, "path:name, name, name/arity or @format")
+	c.checkList("routeInput", goque.IsRouteInputModeEntry, "path:mode or mode, slurp or null")
+	c.checkList("customFuncs", goque.IsCustomFuncName, "a custom function, all or none")
+	c.checkList("haltStatus", func(entry string) bool {
+		_, _, ok := goque.ParseHaltStatusEntry(entry)
+		return ok
+	}, "exitcode:status")
+
+	engineConfig := goque.Config{
+		Filter:          config["jq"].val,
+		EscapeHTML:      parsedEscapeHtml,
+		DecompressLimit: parsedDecompressLimit,
+		BodyLimit:       parsedBodyLimit,
+		MaxDepth:        parsedMaxDepth,
+		MaxArrayLength:  parsedMaxArrayLength,
+		MaxStringLength: parsedMaxStringLength,
+		MaxResults:      parsedMaxResults,
+		MaxOutputSize:   parsedMaxOutputSize,
+		EvalTimeout:     parsedEvalTimeout,
+		DebugMode:       parsedDebugMode,
+		PreserveNumbers: parsedPreserveNumbers,
+		SortKeys:        parsedSortKeys,
+		Indent:          parsedIndent,
+		Slurp:           parsedSlurp,
+		NullInput:       parsedNullInput,
+		CacheSize:       parsedFilterCacheSize,
+
+		FilterPolicy:    parsedFilterPolicy,
+		FilterAllowlist: parsedFilterAllowlist,
+		RoutePolicies:   parseRoutePolicies(config),
+		NamedFilters:    parsedNamedFilters,
+
+		EnvPrefixes: goque.ParseEnvPrefixes(config["envPrefix"].val),
+		FuncAllow:   goque.ParseFuncList(config["funcAllow"].val),
+		FuncDeny:    goque.ParseFuncList(config["funcDeny"].val),
+		CustomFuncs: goque.ParseCustomFuncs(config["customFuncs"].val),
+		HaltStatus:  goque.ParseHaltStatus(config["haltStatus"].val),
 	}
 
-	var code *gojq.Code
-	if config["jq"].val != "" {
-		code = CompileJQCode(config["jq"].val)
+	c.checkEnviron(engineConfig.EnvPrefixes)
+
+	engine, err := goque.NewEngine(engineConfig)
+	if err != nil && c.strict {
+		// Reported with the other errors instead of exiting
+		c.invalid("jq", err.Error())
+	} else if err != nil {
+		log.Fatal().AnErr("JQ", err).Str("code", string(err.(*goque.FilterError).Code)).Msg("Could not compile JQ filter")
+	} else if engineConfig.Filter != "" {
 		log.Info().Msg("JQ filter compiled")
 	}
 
-	return &GoqueParams{
+	if c.strict && len(c.errs) > 0 {
+		return nil, c.errs
+	}
+
+	gp := &GoqueParams{
+		engine:         engine,
 		tracerDisabled: parsedTracerDisable,
 		tracerRatio:    parsedTracerRatio,
 		tracerEndpoint: config["tracerEndpoint"].val,
-		code:           code,
 		host:           config["host"].val,
 		port:           config["port"].val,
 		path:           config["path"].val,
+		oasPath:        config["oasPath"].val,
+		playgroundPath: config["playgroundPath"].val,
 		scheme:         config["scheme"].val,
-		escape:         parsedEscapeHtml,
+		compress:       parsedCompress,
+	}
+
+	return gp, nil
+}
+
+// Returns the route policies of config: the filter policies of
+// routePolicy with the function lists of routeFuncAllow and
+// routeFuncDeny and the input modes of routeInput.
+func parseRoutePolicies(config map[string]*ConfigurationVar) map[string]goque.RoutePolicy {
+	policies := goque.ParseRoutePolicies(config["routePolicy"].val)
+	allow := goque.ParseRouteFuncLists(config["routeFuncAllow"].val)
+	deny := goque.ParseRouteFuncLists(config["routeFuncDeny"].val)
+	inputs := goque.ParseRouteInputModes(config["routeInput"].val)
+
+	if policies == nil && (allow != nil || deny != nil || inputs != nil) {
+		policies = make(map[string]goque.RoutePolicy)
+	}
+	for path, list := range allow {
+		policy := policies[path]
+		policy.FuncAllow = list
+		policies[path] = policy
+	}
+	for path, list := range deny {
+		policy := policies[path]
+		policy.FuncDeny = list
+		policies[path] = policy
+	}
+	for path, mode := range inputs {
+		mode := mode
+		policy := policies[path]
+		policy.Input = &mode
+		policies[path] = policy
 	}
+
+	return policies
+}
+
+// Sets config from the config file, then the env vars, then args
+// parsed with fs. The config file is named by -c or GOQUE_CONFIG, see
+// loadConfigFile. Returns the env vars that were set and the source
+// of each value, by name.
+func parseConfiguration(fs *flag.FlagSet, args []string, config map[string]*ConfigurationVar) (map[string]string, map[string]string, error) {
+	return parseCommandConfiguration(fs, args, config, nil)
 }
 
-// Grabs the handler params from the env vars or command line.
-// Command line has precedence. Returns a HandlerParams object
-// that configures the server & jq evaluation.
-func SetConfiguration(map[string]*ConfigurationVar) (map[string]string, map[string]*ConfigurationVar) {
-	config := GetDefaultConfiguration()
+// Like parseConfiguration, but only the options named in flags get a
+// flag, nil for all. The others are still set by the config file and
+// env vars, and their flags are unknown to fs.
+func parseCommandConfiguration(fs *flag.FlagSet, args []string, config map[string]*ConfigurationVar, flags []string) (map[string]string, map[string]string, error) {
+	if flags == nil {
+		for name := range config {
+			flags = append(flags, name)
+		}
+	}
+
+	flagVals := make(map[string]*string, len(flags))
+	for _, name := range flags {
+		v := config[name]
+		flagVals[name] = fs.String(v.arg, v.val, v.desc)
+	}
+	configFile := fs.String("c", os.Getenv(configFileEnvVar), "Config file, YAML, JSON or TOML")
+
+	// Parse the args
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	sources := make(map[string]string, len(config))
+	for name := range config {
+		sources[name] = sourceDefault
+	}
+
+	if *configFile != "" {
+		set, err := loadConfigFile(*configFile, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range set {
+			sources[name] = sourceFile
+		}
+	}
 
 	// Since logging isn't configured yet, so we're logging at defaultLogLevel.
 	// Don't print evs until we get the loglevel
 	setEnvs := make(map[string]string)
-	for _, v := range config {
+	for name, v := range config {
 		// If the env var exists, set the value
 		if v.envVar != "" {
 			if envVal, ok := os.LookupEnv(v.envVar); ok {
 				setEnvs[v.envVar] = envVal
 				v.val = envVal
+				sources[name] = sourceEnv
 			}
 		}
-
-		// If the command line argument was set, set the value.
-		// Note that this overwrites the env vars.
-		// TODO: figure out how to print items sent by command line
-		flag.StringVar(&v.val, v.arg, v.val, v.desc)
 	}
 
-	// Parse the args
-	flag.Parse()
+	// If the command line argument was set, set the value.
+	// Note that this overwrites the env vars.
+	argNames := make(map[string]string, len(config))
+	for name, v := range config {
+		argNames[v.arg] = name
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if name, ok := argNames[f.Name]; ok {
+			config[name].val = *flagVals[name]
+			sources[name] = sourceFlag
+		}
+	})
 
-	return setEnvs, config
+	return setEnvs, sources, nil
 }
 
 func PrintGoqueParams(gp *GoqueParams) {
 	log.Debug().Msgf("Goque params: %+v", *gp)
+	log.Debug().Msgf("Goque engine config: %+v", gp.engine.Config())
 }
 
 type ConfigurationVar struct {
@@ -192,13 +525,15 @@ type ConfigurationVar struct {
 
 // A struct containing server and jq configuration info.
 type GoqueParams struct {
-	code           *gojq.Code // Compiled JQ if set with env/cli
+	engine         *goque.Engine // Compiles, runs and formats filters, see goque.NewEngine
 	tracerDisabled bool
 	tracerRatio    float64
 	tracerEndpoint string
-	escape         bool   // Escape HTML
 	host           string // The server host
 	port           string // The server port
 	scheme         string // The server scheme
 	path           string // The jq API path
+	oasPath        string // The OpenAPI document path, empty if disabled
+	playgroundPath string // The playground UI path, empty if disabled
+	compress       bool   // Compress responses per Accept-Encoding
 }
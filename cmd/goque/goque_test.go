@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Max-Clark/goque/pkg/goque"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
@@ -37,20 +38,33 @@ func _setArgs(args []string) []string {
 	return oldArgs
 }
 
+// Loads the GoqueParams of args as goque serve does, args[0] being the
+// program name. Panics on invalid configurations.
 func _resetGetGoqueParamsFromStr(args []string) *GoqueParams {
-	oldArgs := os.Args
-	defer func() { os.Args = oldArgs }()
-
-	os.Args = args
-
-	_clearFlags()
+	var stderr bytes.Buffer
 
 	config := GetDefaultConfiguration()
-	setEnvs, config := SetConfiguration(config)
-	gp := ParseGoqueParams(setEnvs, config)
+	setEnvs, _, err := parseConfiguration(newFlagSet("serve", "[flags]", &stderr), args[1:], config)
+	if err != nil {
+		panic(err)
+	}
+
+	gp := loadGoqueParams(setEnvs, config, &stderr)
+	if gp == nil {
+		panic(stderr.String())
+	}
 	return gp
 }
 
+// Parses args as goque serve does, without loading them.
+func _parseConfiguration(args []string, config map[string]*ConfigurationVar) map[string]string {
+	setEnvs, _, err := parseConfiguration(newFlagSet("serve", "[flags]", io.Discard), args, config)
+	if err != nil {
+		panic(err)
+	}
+	return setEnvs
+}
+
 // Waits for the a server to be ready (i.e., return a HTTP response)
 func _waitForServer(client *http.Client, url string) error {
 	var err error
@@ -98,7 +112,7 @@ func Test_main(t *testing.T) {
 			reqBody:        `"test`,
 			reqJqHeader:    `.`,
 			resCode:        400,
-			resBody:        `{"status":"error","message":"readStringSlowPath: unexpected end of input, error found in #5 byte of ...|\"test|..., bigger context ...|\"test|..."}`,
+			resBody:        `{"type":"urn:goque:problem:invalid_body","title":"Invalid request body","status":400,"code":"invalid_body","detail":"readStringSlowPath: unexpected end of input, error found in #5 byte of ...|\"test|..., bigger context ...|\"test|..."}`,
 		},
 	}
 
@@ -158,19 +172,19 @@ func Test_main(t *testing.T) {
 }
 
 func TestConfigDefaults(t *testing.T) {
-	_clearFlags()
 	os.Clearenv()
 
 	log.Info().Msg(reflect.Func.String())
 	defaultConfig := GetDefaultConfiguration()
-	_, config := SetConfiguration(GetDefaultConfiguration())
+	config := GetDefaultConfiguration()
+	_parseConfiguration(nil, config)
 
 	for k, _ := range defaultConfig {
 		assert.Equal(t, defaultConfig[k].val, config[k].val)
 	}
 }
 
-func TestParseGoqueParams(t *testing.T) {
+func TestLoadGoqueParams(t *testing.T) {
 	type args struct {
 		setEnvs map[string]string
 		config  map[string]*ConfigurationVar
@@ -192,10 +206,47 @@ func TestParseGoqueParams(t *testing.T) {
 		}
 	}
 
+	test2Config := goque.Config{
+		DecompressLimit: defaultDecompressLimit,
+		BodyLimit:       defaultBodyLimit,
+		MaxDepth:        defaultMaxDepth,
+		MaxArrayLength:  defaultMaxArrayLength,
+		MaxStringLength: defaultMaxStringLength,
+		MaxResults:      defaultMaxResults,
+		MaxOutputSize:   defaultMaxOutputSize,
+		EvalTimeout:     defaultEvalTimeout,
+		PreserveNumbers: defaultPreserveNumbers,
+		CacheSize:       defaultFilterCacheSize,
+
+		FilterPolicy:    defaultHeaderFilterPolicy,
+		FilterAllowlist: map[string]bool{"GOQUE_FILTER_ALLOWLIST": true}, // A valid filter name
+
+		EnvPrefixes: []string{"GOQUE_JQ_ENV_PREFIX"},
+		FuncAllow:   map[string]bool{"GOQUE_JQ_FUNC_ALLOW": true},
+		FuncDeny:    map[string]bool{"GOQUE_JQ_FUNC_DENY": true},
+	}
+
+	test3Config := test2Config
+	test3Config.Filter = "."
+
+	test2Params := &GoqueParams{
+		tracerDisabled: false,
+		tracerRatio:    1.0,
+		tracerEndpoint: "GOQUE_TRACER_ENDPOINT",
+		host:           "GOQUE_HOST",
+		port:           "GOQUE_PORT",
+		scheme:         "GOQUE_SCHEME",
+		path:           "GOQUE_PATH",
+		oasPath:        "GOQUE_OAS_PATH",
+		playgroundPath: "GOQUE_PLAYGROUND_PATH",
+		compress:       defaultCompress,
+	}
+
 	tests := []struct {
-		name string
-		args args
-		want *GoqueParams
+		name       string
+		args       args
+		want       *GoqueParams
+		wantConfig goque.Config
 	}{
 		{
 			name: "test1 - defaults",
@@ -204,15 +255,33 @@ func TestParseGoqueParams(t *testing.T) {
 				config:  GetDefaultConfiguration(),
 			},
 			want: &GoqueParams{
-				code:           nil,
 				tracerDisabled: defaultTracerDisable,
 				tracerRatio:    defaultTracerRatio,
 				tracerEndpoint: defaultTracerEndpoint,
-				escape:         defaultEscapeHTML,
 				host:           defaultHost,
 				port:           defaultPort,
 				scheme:         defaultScheme,
 				path:           defaultPath,
+				oasPath:        defaultOASPath,
+				compress:       defaultCompress,
+			},
+			wantConfig: goque.Config{
+				EscapeHTML:      defaultEscapeHTML,
+				DecompressLimit: defaultDecompressLimit,
+				BodyLimit:       defaultBodyLimit,
+				MaxDepth:        defaultMaxDepth,
+				MaxArrayLength:  defaultMaxArrayLength,
+				MaxStringLength: defaultMaxStringLength,
+				MaxResults:      defaultMaxResults,
+				MaxOutputSize:   defaultMaxOutputSize,
+				EvalTimeout:     defaultEvalTimeout,
+				PreserveNumbers: defaultPreserveNumbers,
+				CacheSize:       defaultFilterCacheSize,
+
+				FilterPolicy: defaultHeaderFilterPolicy,
+
+				CustomFuncs: goque.CustomFuncNames(),
+				HaltStatus:  map[int]int{0: 200, 5: 422},
 			},
 		},
 		{
@@ -221,17 +290,8 @@ func TestParseGoqueParams(t *testing.T) {
 				setEnvs: map[string]string{},
 				config:  test2Prep,
 			},
-			want: &GoqueParams{
-				code:           nil,
-				tracerDisabled: false,
-				tracerRatio:    1.0,
-				tracerEndpoint: "GOQUE_TRACER_ENDPOINT",
-				escape:         false,
-				host:           "GOQUE_HOST",
-				port:           "GOQUE_PORT",
-				scheme:         "GOQUE_SCHEME",
-				path:           "GOQUE_PATH",
-			},
+			want:       test2Params,
+			wantConfig: test2Config,
 		},
 		{
 			name: "test3 - compile jq",
@@ -239,29 +299,26 @@ func TestParseGoqueParams(t *testing.T) {
 				setEnvs: map[string]string{},
 				config:  test3Prep,
 			},
-			want: &GoqueParams{
-				code:           CompileJQCode("."),
-				tracerDisabled: false,
-				tracerRatio:    1.0,
-				tracerEndpoint: "GOQUE_TRACER_ENDPOINT",
-				escape:         false,
-				host:           "GOQUE_HOST",
-				port:           "GOQUE_PORT",
-				scheme:         "GOQUE_SCHEME",
-				path:           "GOQUE_PATH",
-			},
+			want:       test2Params,
+			wantConfig: test3Config,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ParseGoqueParams(tt.args.setEnvs, tt.args.config); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ParseGoqueParams() = %v, want %v", got, tt.want)
+			got := loadGoqueParams(tt.args.setEnvs, tt.args.config, io.Discard)
+			if gotConfig := got.engine.Config(); !reflect.DeepEqual(gotConfig, tt.wantConfig) {
+				t.Errorf("loadGoqueParams() engine config = %v, want %v", gotConfig, tt.wantConfig)
+			}
+
+			got.engine = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadGoqueParams() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestSetConfiguration(t *testing.T) {
+func TestParseConfiguration(t *testing.T) {
 	type args struct {
 		config  map[string]*ConfigurationVar
 		envVars map[string]string
@@ -286,7 +343,6 @@ func TestSetConfiguration(t *testing.T) {
 			args: args{
 				config:  GetDefaultConfiguration(),
 				envVars: make(map[string]string),
-				flags:   []string{os.Args[0]},
 			},
 			want1: GetDefaultConfiguration(),
 			want:  make(map[string]string),
@@ -296,36 +352,47 @@ func TestSetConfiguration(t *testing.T) {
 			args: args{
 				config:  GetDefaultConfiguration(),
 				envVars: test2EnvVars,
-				flags:   []string{os.Args[0]},
 			},
 			want1: test2Configuration,
 			want:  test2EnvVars,
 		},
+		{
+			name: "test 3 - flags override envs",
+			args: args{
+				config:  GetDefaultConfiguration(),
+				envVars: map[string]string{"GOQUE_PORT": "8081"},
+				flags:   []string{"-p", "8082"},
+			},
+			want1: func() map[string]*ConfigurationVar {
+				config := GetDefaultConfiguration()
+				config["port"].val = "8082"
+				return config
+			}(),
+			want: map[string]string{"GOQUE_PORT": "8081"},
+		},
 	}
 	for _, tt := range tests {
 		environ := os.Environ()
 		defer _setEnvFromEnviron(environ)
 
-		_clearFlags()
 		os.Clearenv()
 
 		for k, v := range tt.args.envVars {
 			os.Setenv(k, v)
 		}
 
-		_setArgs(tt.args.flags)
-
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1 := SetConfiguration(tt.args.config)
+			got1 := tt.args.config
+			got := _parseConfiguration(tt.args.flags, got1)
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("SetConfiguration() got = %v, want %v", got, tt.want)
+				t.Errorf("parseConfiguration() got = %v, want %v", got, tt.want)
 			}
 
 			assert.Len(t, got1, len(tt.args.config))
 
 			for k, _ := range got1 {
 				if !reflect.DeepEqual(got1[k], tt.want1[k]) {
-					t.Errorf("SetConfiguration() got1 = %v, want %v", got1[k], tt.want1[k])
+					t.Errorf("parseConfiguration() got1 = %v, want %v", got1[k], tt.want1[k])
 				}
 			}
 		})
@@ -1,18 +1,80 @@
 package main
 
 import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Max-Clark/goque/pkg/goque"
 	"github.com/gofiber/contrib/otelfiber"
 	"github.com/gofiber/fiber/v2"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("goque")
+
 // Starts the http server. Takes params for escaping html,
 // server properties, and other handler variables. Handles
 // json POSTs on hp.path.
 func RunServer(gp *GoqueParams) {
+	app := NewApp(gp)
+
+	// TODO: Create better validation for server urls
+	// :<port> is supported
+	// [<scheme>//]<host>[:<port>] is supported
+	var parsedPort = gp.port
+	if parsedPort != "" {
+		parsedPort = ":" + parsedPort
+	}
+
+	var parsedScheme = gp.scheme
+	if parsedScheme != "" {
+		parsedScheme = gp.scheme + "//"
+	}
+
+	var url = parsedScheme + gp.host + parsedPort
+
+	log.Fatal().AnErr("RunServer", app.Listen(url)).Msg("")
+}
+
+// Returns the paths of the jq routes: the server path, then the other
+// paths with a route policy, sorted. The /validate path of the server
+// path is not a jq route.
+func jqPaths(gp *GoqueParams) []string {
+	paths := []string{gp.path}
+	for path := range gp.engine.Config().RoutePolicies {
+		if path != gp.path && path != validatePath(gp) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths[1:])
+
+	return paths
+}
+
+func validatePath(gp *GoqueParams) string {
+	return strings.TrimSuffix(gp.path, "/") + "/validate"
+}
+
+// Returns the fiber BodyLimit for the engine's BodyLimit. fiber reads
+// 0 as its 4 MiB default, for the engine it is unlimited.
+func fiberBodyLimit(limit int) int {
+	if limit == 0 {
+		return math.MaxInt
+	}
+	return limit
+}
+
+// Creates the fiber app with middleware, the jq routes (see jqPaths)
+// and the /validate route served by gp.engine, the /version route, the
+// OpenAPI document (see OpenAPISpec) and the playground if enabled.
+func NewApp(gp *GoqueParams) *fiber.App {
+	config := gp.engine.Config()
 	json := jsoniter.Config{
-		EscapeHTML: gp.escape,
+		EscapeHTML: config.EscapeHTML,
+		UseNumber:  config.PreserveNumbers,
 	}.Froze()
 
 	app := fiber.New(fiber.Config{
@@ -20,6 +82,8 @@ func RunServer(gp *GoqueParams) {
 		DisableStartupMessage: true,
 		JSONEncoder:           json.Marshal,
 		JSONDecoder:           json.Unmarshal,
+		BodyLimit:             fiberBodyLimit(config.BodyLimit),
+		ErrorHandler:          gp.engine.HandleError,
 	})
 
 	// app.Use(logger.New(logger.Config{
@@ -28,28 +92,45 @@ func RunServer(gp *GoqueParams) {
 
 	app.Use(otelfiber.Middleware())
 
-	// TODO: Host OAS spec
+	if gp.compress {
+		app.Use(goque.CompressResponse)
+	}
 
-	app.Post(gp.path, func(c *fiber.Ctx) error {
-		_, span := tracer.Start(c.UserContext(), "PostHandler")
-		defer span.End()
-		return HandlePost(c, gp)
-	})
+	if gp.oasPath != "" {
+		// The spec only depends on gp, encode it once
+		spec, err := goque.JSONAPI(false, true).Marshal(OpenAPISpec(gp))
+		if err != nil {
+			log.Fatal().AnErr("OpenAPISpec", err).Msg("Could not encode the OpenAPI document")
+		}
 
-	// TODO: Create better validation for server urls
-	// :<port> is supported
-	// [<scheme>//]<host>[:<port>] is supported
-	var parsedPort = gp.port
-	if parsedPort != "" {
-		parsedPort = ":" + parsedPort
+		app.Get(gp.oasPath, func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			return c.Send(spec)
+		})
 	}
 
-	var parsedScheme = gp.scheme
-	if parsedScheme != "" {
-		parsedScheme = gp.scheme + "//"
+	// Each jq route runs with its own header filter policy
+	handlePost := gp.engine.Handler()
+	for _, path := range jqPaths(gp) {
+		app.Post(path, func(c *fiber.Ctx) error {
+			_, span := tracer.Start(c.UserContext(), "PostHandler")
+			defer span.End()
+			return handlePost(c)
+		})
 	}
 
-	var url = parsedScheme + gp.host + parsedPort
+	handleValidate := gp.engine.ValidateHandler()
+	app.Post(validatePath(gp), func(c *fiber.Ctx) error {
+		_, span := tracer.Start(c.UserContext(), "ValidateHandler")
+		defer span.End()
+		return handleValidate(c)
+	})
 
-	log.Fatal().AnErr("RunServer", app.Listen(url)).Msg("")
+	app.Get("/version", HandleVersion)
+
+	if gp.playgroundPath != "" {
+		AddPlayground(app, gp)
+	}
+
+	return app
 }
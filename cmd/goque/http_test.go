@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestHandlerCompressed(t *testing.T) {
+	args := []string{"goque"}
+	gp := _resetGetGoqueParamsFromStr(args)
+	app := NewApp(gp)
+
+	value := strings.Repeat("nope.", 256)
+	body, _ := goque.CompressBody([]byte(`{"pineapple":"`+value+`"}`), "zstd")
+
+	req := httptest.NewRequest("POST", defaultPath, bytes.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("content-encoding", "zstd")
+	req.Header.Set("accept-encoding", "gzip")
+	req.Header.Set("x-goque-jq-filter", ".pineapple")
+
+	res, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("content-encoding"))
+
+	resBody, _ := io.ReadAll(res.Body)
+	got, err := goque.DecompressBody(resBody, "gzip", 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, `"`+value+`"`, string(got))
+}
+
+func TestHandlerOutputFormat(t *testing.T) {
+	body := `{"b":{"d":1,"c":[true]},"a":12345678901234567890}`
+
+	tests := []struct {
+		name     string
+		args     []string
+		sortKeys string
+		indent   string
+		resCode  int
+		resBody  string
+	}{
+		{"sorted", nil, "true", "", fiber.StatusOK, `{"a":12345678901234567890,"b":{"c":[true],"d":1}}`},
+		{"indented", nil, "true", "2", fiber.StatusOK, "{\n  \"a\": 12345678901234567890,\n  \"b\": {\n    \"c\": [\n      true\n    ],\n    \"d\": 1\n  }\n}"},
+		{"tabs", nil, "true", "tab", fiber.StatusOK, "{\n\t\"a\": 12345678901234567890,\n\t\"b\": {\n\t\t\"c\": [\n\t\t\ttrue\n\t\t],\n\t\t\"d\": 1\n\t}\n}"},
+		{"config default", []string{"-sk", "true", "-in", "1"}, "", "", fiber.StatusOK, "{\n \"a\": 12345678901234567890,\n \"b\": {\n  \"c\": [\n   true\n  ],\n  \"d\": 1\n }\n}"},
+		{"header overrides config", []string{"-sk", "true", "-in", "1"}, "", "0", fiber.StatusOK, `{"a":12345678901234567890,"b":{"c":[true],"d":1}}`},
+		{"invalid indent", nil, "", "wide", fiber.StatusBadRequest, ""},
+		{"invalid sort keys", nil, "sure", "", fiber.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(_resetGetGoqueParamsFromStr(append([]string{"goque"}, tt.args...)))
+
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(body))
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("x-goque-jq-filter", ".")
+			if tt.sortKeys != "" {
+				req.Header.Set("x-goque-sort-keys", tt.sortKeys)
+			}
+			if tt.indent != "" {
+				req.Header.Set("x-goque-indent", tt.indent)
+			}
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+
+			if tt.resBody != "" {
+				resBody, _ := io.ReadAll(res.Body)
+				assert.Equal(t, tt.resBody, string(resBody))
+			}
+		})
+	}
+}
+
+func TestHandlerInputModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		headers     map[string]string
+		contentType string
+		body        string
+		filter      string
+		resCode     int
+		resBody     string
+	}{
+		{"slurp", nil, map[string]string{"x-goque-slurp": "true"}, "application/json", `{"a":1} {"a":2}`, "map(.a) | add", fiber.StatusOK, `3`},
+		{"slurp ndjson", nil, map[string]string{"x-goque-slurp": "true"}, "application/x-ndjson", "{\"a\":1}\n{\"a\":2}\n", "length", fiber.StatusOK, `2`},
+		{"slurp empty", nil, map[string]string{"x-goque-slurp": "true"}, "application/json", "", ".", fiber.StatusOK, `[]`},
+		{"slurp xml", nil, map[string]string{"x-goque-slurp": "true"}, "application/xml", "<a>1</a>", ".[0].a", fiber.StatusOK, `"1"`},
+		{"slurp config", []string{"-sl", "true"}, nil, "application/json", `1 2 3`, "add", fiber.StatusOK, `6`},
+		{"slurp header overrides config", []string{"-sl", "true"}, map[string]string{"x-goque-slurp": "false"}, "application/json", `[1,2,3]`, "add", fiber.StatusOK, `6`},
+		{"slurp array limit", []string{"-ma", "2"}, map[string]string{"x-goque-slurp": "true"}, "application/json", `1 2 3`, "add", fiber.StatusUnprocessableEntity, ""},
+		{"null input", nil, map[string]string{"x-goque-null-input": "true"}, "", "", "[range(3)]", fiber.StatusOK, `[0,1,2]`},
+		{"null input ignores body", []string{"-ni", "true"}, nil, "application/json", `{"a":1}`, ".", fiber.StatusOK, `null`},
+		{"invalid header", nil, map[string]string{"x-goque-slurp": "maybe"}, "application/json", `1`, ".", fiber.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(_resetGetGoqueParamsFromStr(append([]string{"goque"}, tt.args...)))
+
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(tt.body))
+			req.Header.Set("content-type", tt.contentType)
+			req.Header.Set("x-goque-jq-filter", tt.filter)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+
+			if tt.resBody != "" {
+				resBody, _ := io.ReadAll(res.Body)
+				assert.Equal(t, tt.resBody, string(resBody))
+			}
+		})
+	}
+}
+
+func TestHandlerRouteInputModes(t *testing.T) {
+	app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-sl", "true", "-ri", "/bulk:slurp,null,/plain:"}))
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		body    string
+		filter  string
+		resBody string
+	}{
+		{"config", defaultPath, nil, `1 2 3`, "add", `6`},
+		{"route", "/bulk", nil, `1 2 3`, "[., [inputs]]", `[null,[[1,2,3]]]`},
+		{"route without modes", "/plain", nil, `[1,2,3]`, "add", `6`},
+		{"header overrides route", "/bulk", map[string]string{"x-goque-null-input": "false"}, `1 2 3`, "add", `6`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("x-goque-jq-filter", tt.filter)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, res.StatusCode)
+
+			resBody, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tt.resBody, string(resBody))
+		})
+	}
+}
+
+func TestHandlerInputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		headers map[string]string
+		body    string
+		filter  string
+		resCode int
+		resBody string
+	}{
+		{"reduce inputs", nil, nil, `{"a":1} {"a":2} {"a":3}`, "reduce inputs as $x (.; .a += $x.a)", fiber.StatusOK, `{"a":6}`},
+		{"input", nil, nil, "1\n2\n3", "[., input]", fiber.StatusOK, `[1,2]`},
+		{"single value", nil, nil, `1`, "[inputs]", fiber.StatusOK, `[]`},
+		{"null input", nil, map[string]string{"x-goque-null-input": "true"}, `1 2 3`, "[inputs]", fiber.StatusOK, `[1,2,3]`},
+		{"slurp", nil, map[string]string{"x-goque-slurp": "true"}, `1 2 3`, "[., [inputs]]", fiber.StatusOK, `[[1,2,3],[]]`},
+		{"compiled", []string{"-jq", "[., inputs]"}, nil, `1 2 3`, "", fiber.StatusOK, `[1,2,3]`},
+		{"compiled single value", []string{"-jq", "[., inputs]"}, nil, `1`, "", fiber.StatusOK, `[1]`},
+		{"no more inputs", nil, nil, `1`, "input", fiber.StatusUnprocessableEntity, `{"type":"urn:goque:problem:filter_runtime_error","title":"Filter runtime error","status":422,"detail":"No more inputs","code":"filter_runtime_error"}`},
+		{"invalid stream", nil, nil, `1 2 {`, "[., inputs]", fiber.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(_resetGetGoqueParamsFromStr(append([]string{"goque"}, tt.args...)))
+
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(tt.body))
+			req.Header.Set("content-type", "application/json")
+			if tt.filter != "" {
+				req.Header.Set("x-goque-jq-filter", tt.filter)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+
+			if tt.resBody != "" {
+				resBody, _ := io.ReadAll(res.Body)
+				assert.Equal(t, tt.resBody, string(resBody))
+			}
+		})
+	}
+}
+
+func TestHandlerBodyLimits(t *testing.T) {
+	gp := _resetGetGoqueParamsFromStr([]string{"goque", "-bl", "64", "-md", "2"})
+	app := NewApp(gp)
+
+	tests := []struct {
+		body    string
+		resCode int
+		resBody string
+	}{
+		{
+			body:    `{"a":[1]}`,
+			resCode: fiber.StatusOK,
+			resBody: `{"a":[1]}`,
+		},
+		{
+			body:    `{"a":[[1]]}`,
+			resCode: fiber.StatusUnprocessableEntity,
+			resBody: `{"type":"urn:goque:problem:body_limit_exceeded","title":"Body limit exceeded","status":422,"detail":"Nesting depth exceeds the limit of 2 at .a[0]","code":"body_limit_exceeded"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", defaultPath, bytes.NewReader([]byte(tt.body)))
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("x-goque-jq-filter", ".")
+
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.resCode, res.StatusCode)
+
+		resBody, _ := io.ReadAll(res.Body)
+		assert.JSONEq(t, tt.resBody, string(resBody))
+	}
+
+	// fasthttp closes the connection on bodies over the limit, so go
+	// through the handler directly
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	c.Context().Request.SetBody([]byte(`{"a":"` + strings.Repeat("x", 64) + `"}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("x-goque-jq-filter", ".")
+
+	assert.NoError(t, gp.engine.Handler()(c))
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, c.Response().StatusCode())
+	assert.JSONEq(t, `{"type":"urn:goque:problem:body_too_large","title":"Request body too large","status":413,"detail":"Request body exceeds the limit of 64 bytes","code":"body_too_large"}`, string(c.Response().Body()))
+}
+
+// A body limit of 0 is unlimited, not fiber's default
+func TestHandlerNoBodyLimit(t *testing.T) {
+	app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-bl", "0"}))
+
+	req := httptest.NewRequest("POST", defaultPath, strings.NewReader(`"`+strings.Repeat("x", fiber.DefaultBodyLimit)+`"`))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("x-goque-jq-filter", "length")
+
+	res, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, res.StatusCode)
+
+	resBody, _ := io.ReadAll(res.Body)
+	assert.Equal(t, strconv.Itoa(fiber.DefaultBodyLimit), string(resBody))
+}
+
+func TestHandlerOutputLimits(t *testing.T) {
+	gp := _resetGetGoqueParamsFromStr([]string{"goque", "-mr", "3", "-mo", "16"})
+	app := NewApp(gp)
+
+	tests := []struct {
+		filter  string
+		resCode int
+		resBody string
+	}{
+		{
+			filter:  `null, null, "ok"`,
+			resCode: fiber.StatusOK,
+			resBody: `"ok"`,
+		},
+		{
+			filter:  `range(1e8) | null`,
+			resCode: fiber.StatusUnprocessableEntity,
+			resBody: `{"type":"urn:goque:problem:result_limit_exceeded","title":"Result limit exceeded","status":422,"detail":"Filter emitted more than 3 results","code":"result_limit_exceeded"}`,
+		},
+		{
+			filter:  `[range(100)]`,
+			resCode: fiber.StatusInsufficientStorage,
+			resBody: `{"type":"urn:goque:problem:output_limit_exceeded","title":"Output limit exceeded","status":507,"detail":"Result exceeds the output limit of 16 bytes","code":"output_limit_exceeded"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", defaultPath, bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("x-goque-jq-filter", tt.filter)
+
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.resCode, res.StatusCode)
+
+		resBody, _ := io.ReadAll(res.Body)
+		assert.JSONEq(t, tt.resBody, string(resBody))
+	}
+}
+
+func TestHandlerFilterPolicies(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pineapple.jq"), []byte(".pineapple"), 0o600))
+
+	// Only the allowlisted named filter runs on the server path, any
+	// filter not calling debug on the /internal route
+	app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-fn", dir, "-fp", "allowlist", "-fa", "pineapple",
+		"-rp", "/internal:allow", "-rd", "/internal:debug"}))
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		resCode int
+	}{
+		{"named filter", defaultPath, map[string]string{"x-goque-filter-name": "pineapple"}, fiber.StatusOK},
+		{"header filter", defaultPath, map[string]string{"x-goque-jq-filter": ".pineapple"}, fiber.StatusForbidden},
+		{"internal header filter", "/internal", map[string]string{"x-goque-jq-filter": ".pineapple"}, fiber.StatusOK},
+		{"internal named filter", "/internal", map[string]string{"x-goque-filter-name": "pineapple"}, fiber.StatusOK},
+		{"internal denied function", "/internal", map[string]string{"x-goque-jq-filter": ".pineapple | debug"}, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"pineapple":"nope."}`))
+			req.Header.Set("content-type", "application/json")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+		})
+	}
+
+	spec := OpenAPISpec(_resetGetGoqueParamsFromStr([]string{"goque", "-fn", dir, "-rp", "/public:deny"}))
+	op := spec["paths"].(fiber.Map)[defaultPath].(fiber.Map)["post"].(fiber.Map)
+	assert.Contains(t, _headerParamNames(op), "x-goque-filter-name")
+	assert.Contains(t, op["responses"], "404")
+
+	op = spec["paths"].(fiber.Map)["/public"].(fiber.Map)["post"].(fiber.Map)
+	assert.Equal(t, "runFilter2", op["operationId"])
+	assert.NotContains(t, _headerParamNames(op), "x-goque-jq-filter")
+}
+
+func TestHandleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		resCode int
+		resBody string
+	}{
+		{
+			"valid",
+			`{"filter":".peanuts|not","format":true}`,
+			fiber.StatusOK,
+			`{"status":"ok","valid":true,"formatted":".peanuts | not"}`,
+		},
+		{
+			"invalid",
+			`{"filter":".peanuts |"}`,
+			fiber.StatusUnprocessableEntity,
+			`{"status":"error","valid":false,"message":"unexpected EOF","errors":[{"code":"filter_parse_error","message":"unexpected EOF","offset":10,"line":1,"column":11}]}`,
+		},
+		{
+			"denied function",
+			`{"filter":".pineapple | debug"}`,
+			fiber.StatusUnprocessableEntity,
+			`{"status":"error","valid":false,"message":"function not allowed: debug/0 at line 1, column 14","errors":[{"code":"function_not_allowed","message":"function not allowed: debug/0 at line 1, column 14","offset":13,"line":1,"column":14}]}`,
+		},
+		{
+			"no filter",
+			`{}`,
+			fiber.StatusBadRequest,
+			`{"type":"urn:goque:problem:missing_filter","title":"Missing filter","status":400,"detail":"A JQ filter was not sent with request","code":"missing_filter"}`,
+		},
+	}
+
+	gp := _resetGetGoqueParamsFromStr([]string{"goque", "-fd", "debug"})
+	app := NewApp(gp)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", defaultPath+"/validate", strings.NewReader(tt.body))
+			req.Header.Set("content-type", "application/json")
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+
+			resBody, _ := io.ReadAll(res.Body)
+			assert.JSONEq(t, tt.resBody, string(resBody))
+		})
+	}
+}
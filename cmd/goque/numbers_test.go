@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerPreserveNumbers(t *testing.T) {
+	body := `{"id":9007199254740993,"big":123456789012345678901234567890,"neg":-9223372036854775809,"price":1.5}`
+
+	tests := []struct {
+		name     string
+		preserve string
+		filter   string
+		resBody  string
+	}{
+		{"array", "true", "[.id, .big]", `[9007199254740993,123456789012345678901234567890]`},
+		{"id", "true", ".id", `9007199254740993`},
+		{"arithmetic", "true", ".id + 1", `9007199254740994`},
+		{"big int", "true", ".big", `123456789012345678901234567890`},
+		{"negative big int", "true", ".neg", `-9223372036854775809`},
+		{"compare", "true", ".id == 9007199254740992", `false`},
+		{"decimal", "true", ".price * 2", `3`},
+		{"disabled", "false", ".id", `9007199254740992`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-pn", tt.preserve}))
+
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(body))
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("x-goque-jq-filter", tt.filter)
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, res.StatusCode)
+
+			resBody, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tt.resBody, string(resBody))
+		})
+	}
+}
+
+func TestHandlerOutOfRangeNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		preserve string
+		body     string
+		resCode  int
+		resBody  string
+	}{
+		{"out of range", "true", `{"c":[1e400]}`, fiber.StatusBadRequest, `"Number 1e400 is out of range at .c[0]"`},
+		{"out of range stream", "true", `1 {"c":-1.5e400}`, fiber.StatusBadRequest, `"Number -1.5e400 is out of range at .c"`},
+		{"out of range disabled", "false", `{"c":[1e400]}`, fiber.StatusBadRequest, ""},
+		{"underflow", "true", `{"c":[1e-400]}`, fiber.StatusOK, `0`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-pn", tt.preserve}))
+
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(tt.body))
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("x-goque-jq-filter", ".c[0]?")
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resCode, res.StatusCode)
+
+			resBody, _ := io.ReadAll(res.Body)
+			if tt.resCode == fiber.StatusOK {
+				assert.Equal(t, tt.resBody, string(resBody))
+			} else {
+				assert.Contains(t, string(resBody), `"code":"invalid_body"`)
+				assert.Contains(t, string(resBody), tt.resBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The OpenAPI version of the document served at GOQUE_OAS_PATH.
+const openAPIVersion = "3.0.3"
+
+// Returns the OpenAPI 3 document of the routes configured by p: the
+// jq routes with the headers their policy allows, the /validate route
+// and /version. Errors are described by the Problem schema.
+func OpenAPISpec(p *GoqueParams) fiber.Map {
+	paths := fiber.Map{
+		validatePath(p): fiber.Map{"post": validateOperation()},
+		"/version":      fiber.Map{"get": versionOperation()},
+	}
+	for i, path := range jqPaths(p) {
+		operationID := "runFilter"
+		if i > 0 {
+			operationID += strconv.Itoa(i + 1)
+		}
+		paths[path] = fiber.Map{"post": jqOperation(p, path, operationID)}
+	}
+
+	return fiber.Map{
+		"openapi": openAPIVersion,
+		"info": fiber.Map{
+			"title":       "goque",
+			"description": "A high throughput HTTP JQ processor based on fiber and gojq.",
+			"version":     GetVersionInfo().Version,
+		},
+		"paths": paths,
+		"components": fiber.Map{
+			"schemas": fiber.Map{
+				"Problem":          problemSchema(),
+				"FilterError":      filterErrorSchema(),
+				"Debug":            debugSchema(),
+				"DebugResult":      debugResultSchema(),
+				"ValidateRequest":  validateRequestSchema(),
+				"ValidateResponse": validateResponseSchema(),
+				"VersionInfo":      versionInfoSchema(),
+			},
+		},
+	}
+}
+
+func jqOperation(p *GoqueParams, path string, operationID string) fiber.Map {
+	config := p.engine.Config().RouteConfig(path)
+
+	description := "Runs a JQ filter against the request body and returns the first non-null result."
+	if config.Filter != "" {
+		description += " The configured filter is `" + config.Filter + "`."
+	}
+
+	// Unknown filter names are the only 404s of the route
+	names := goque.NamedFilterNames(&config)
+	excluded := []goque.ErrorCode{goque.ErrMethodNotAllowed}
+	if len(names) == 0 {
+		excluded = append(excluded, goque.ErrNotFound)
+	}
+
+	params := []fiber.Map{}
+	if config.FilterPolicy != goque.FilterPolicyDeny {
+		filterDescription := "JQ filter run against the body, overriding the configured filter."
+		if config.FilterPolicy == goque.FilterPolicyAllowlist {
+			filterDescription += " Only allowlisted filters are accepted."
+		}
+		params = append(params,
+			headerParam("x-goque-jq-filter", filterDescription, config.Filter == "" && len(names) == 0, fiber.Map{"type": "string"}),
+			headerParam("x-goque-jq-filter-encoding",
+				"Set to percent when x-goque-jq-filter is percent-encoded UTF-8, for filters with newlines or non-ASCII characters.", false,
+				fiber.Map{"type": "string", "enum": []string{"percent"}}))
+
+		if len(names) > 0 {
+			params = append(params, headerParam("x-goque-filter-name",
+				"Name of a filter registered with the server, run instead of x-goque-jq-filter.", false,
+				fiber.Map{"type": "string", "enum": names}))
+		}
+	}
+	if config.DebugMode {
+		params = append(params, headerParam("x-goque-debug",
+			"Return the debug and stderr messages, evaluation time and result count with the result.", false, boolSchema()))
+	}
+	params = append(params,
+		headerParam("x-goque-sort-keys", "Sort object keys of JSON results. Defaults to "+strconv.FormatBool(config.SortKeys)+".", false, boolSchema()),
+		headerParam("x-goque-indent", "Indent of JSON results, 0 (compact) to "+strconv.Itoa(goque.MaxIndent)+" spaces or tab.", false,
+			fiber.Map{"type": "string", "pattern": "^([0-" + strconv.Itoa(goque.MaxIndent) + "]|tab)$"}),
+		headerParam("x-goque-slurp", "Combine all JSON values in the body into an array. Defaults to "+strconv.FormatBool(config.Slurp)+".", false, boolSchema()),
+		headerParam("x-goque-null-input", "Run the filter against null, the body is optional. Defaults to "+strconv.FormatBool(config.NullInput)+".", false, boolSchema()),
+		headerParam(fiber.HeaderContentEncoding, "Compression of the body.", false,
+			fiber.Map{"type": "string", "enum": goque.DecodableEncodings()}),
+	)
+
+	anyValue := fiber.Map{"description": "Any JSON value"}
+	result := fiber.Map{
+		"description": "The first non-null result of the filter, in the format negotiated with Accept.",
+		"content": fiber.Map{
+			fiber.MIMEApplicationJSON: fiber.Map{"schema": anyValue},
+			fiber.MIMEApplicationXML:  fiber.Map{"schema": fiber.Map{"type": "string"}},
+		},
+	}
+	if config.DebugMode {
+		result["description"] = result["description"].(string) + " x-goque-debug requests wrap it in a DebugResult."
+		result["content"].(fiber.Map)[fiber.MIMEApplicationJSON] = fiber.Map{"schema": fiber.Map{"anyOf": []fiber.Map{anyValue, schemaRef("DebugResult")}}}
+		result["headers"] = fiber.Map{
+			"x-goque-eval-time":    fiber.Map{"description": "Evaluation time of x-goque-debug requests, e.g. 0.5ms.", "schema": fiber.Map{"type": "string"}},
+			"x-goque-result-count": fiber.Map{"description": "Values emitted by the filter for x-goque-debug requests.", "schema": fiber.Map{"type": "integer"}},
+		}
+	}
+	responses := fiber.Map{"200": result}
+
+	// Halts send the halt_error value with the mapped status
+	for _, status := range sortedHaltStatuses(config) {
+		key := strconv.Itoa(status)
+		if _, ok := responses[key]; !ok {
+			responses[key] = fiber.Map{
+				"description": "The filter stopped with halt_error, the body is the value passed to it.",
+				"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": anyValue}},
+			}
+		}
+	}
+
+	for status, codes := range problemStatuses(excluded...) {
+		key := strconv.Itoa(status)
+		if r, ok := responses[key].(fiber.Map); ok {
+			r["description"] = r["description"].(string) + " Or a problem: " + strings.Join(codes, ", ") + "."
+			r["content"].(fiber.Map)[goque.MIMEApplicationProblemJSON] = fiber.Map{"schema": schemaRef("Problem")}
+			continue
+		}
+		responses[key] = problemResponse(codes)
+	}
+
+	return fiber.Map{
+		"operationId": operationID,
+		"summary":     "Run a JQ filter",
+		"description": description,
+		"parameters":  params,
+		"requestBody": fiber.Map{
+			"required": !config.NullInput,
+			"content": fiber.Map{
+				fiber.MIMEApplicationJSON: fiber.Map{"schema": anyValue},
+				"application/x-ndjson":    fiber.Map{"schema": fiber.Map{"type": "string"}},
+				fiber.MIMEApplicationXML:  fiber.Map{"schema": fiber.Map{"type": "string"}},
+				fiber.MIMETextXML:         fiber.Map{"schema": fiber.Map{"type": "string"}},
+			},
+		},
+		"responses": responses,
+	}
+}
+
+func validateOperation() fiber.Map {
+	responses := fiber.Map{
+		"200": fiber.Map{
+			"description": "The filter is valid.",
+			"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": schemaRef("ValidateResponse")}},
+		},
+		"422": fiber.Map{
+			"description": "The filter is invalid.",
+			"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": schemaRef("ValidateResponse")}},
+		},
+		"400": problemResponse([]string{string(goque.ErrInvalidBody), string(goque.ErrMissingFilter)}),
+	}
+
+	return fiber.Map{
+		"operationId": "validateFilter",
+		"summary":     "Check a JQ filter without running it",
+		"requestBody": fiber.Map{
+			"required": true,
+			"content":  fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": schemaRef("ValidateRequest")}},
+		},
+		"responses": responses,
+	}
+}
+
+func versionOperation() fiber.Map {
+	return fiber.Map{
+		"operationId": "getVersion",
+		"summary":     "Get the version of goque",
+		"responses": fiber.Map{
+			"200": fiber.Map{
+				"description": "The version of goque.",
+				"content":     fiber.Map{fiber.MIMEApplicationJSON: fiber.Map{"schema": schemaRef("VersionInfo")}},
+			},
+		},
+	}
+}
+
+func headerParam(name string, description string, required bool, schema fiber.Map) fiber.Map {
+	return fiber.Map{"name": name, "in": "header", "description": description, "required": required, "schema": schema}
+}
+
+func boolSchema() fiber.Map {
+	return fiber.Map{"type": "string", "enum": []string{"true", "false"}}
+}
+
+func schemaRef(name string) fiber.Map {
+	return fiber.Map{"$ref": "#/components/schemas/" + name}
+}
+
+func problemResponse(codes []string) fiber.Map {
+	return fiber.Map{
+		"description": "A problem: " + strings.Join(codes, ", ") + ".",
+		"content":     fiber.Map{goque.MIMEApplicationProblemJSON: fiber.Map{"schema": schemaRef("Problem")}},
+	}
+}
+
+// Returns the sorted error codes by status, without the excluded
+// codes.
+func problemStatuses(exclude ...goque.ErrorCode) map[int][]string {
+	excluded := make(map[goque.ErrorCode]bool, len(exclude))
+	for _, code := range exclude {
+		excluded[code] = true
+	}
+
+	statuses := make(map[int][]string)
+	for _, code := range goque.ErrorCodes() {
+		if !excluded[code] {
+			statuses[code.Status()] = append(statuses[code.Status()], string(code))
+		}
+	}
+
+	return statuses
+}
+
+// Returns the statuses halts can be sent with that config maps.
+func sortedHaltStatuses(config goque.Config) []int {
+	var statuses []int
+	for _, status := range config.HaltStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	return statuses
+}
+
+func problemSchema() fiber.Map {
+	codes := []string{}
+	for _, code := range goque.ErrorCodes() {
+		codes = append(codes, string(code))
+	}
+
+	return fiber.Map{
+		"type":        "object",
+		"description": "An RFC 7807 problem detail. The location members are set for filter errors.",
+		"required":    []string{"type", "title", "status", "code"},
+		"properties": fiber.Map{
+			"type":   fiber.Map{"type": "string", "example": "urn:goque:problem:filter_parse_error"},
+			"title":  fiber.Map{"type": "string"},
+			"status": fiber.Map{"type": "integer"},
+			"detail": fiber.Map{"type": "string"},
+			"code":   fiber.Map{"type": "string", "enum": codes},
+			"offset": fiber.Map{"type": "integer", "description": "Byte offset of the error in the filter"},
+			"line":   fiber.Map{"type": "integer", "description": "1-based line of the error in the filter"},
+			"column": fiber.Map{"type": "integer", "description": "1-based column of the error in the filter"},
+			"value":  fiber.Map{"description": "The value passed to error(...)"},
+			"debug":  schemaRef("Debug"),
+		},
+	}
+}
+
+func debugSchema() fiber.Map {
+	return fiber.Map{
+		"type":        "object",
+		"description": "Debug info for x-goque-debug requests.",
+		"required":    []string{"messages", "evalTimeMs", "resultCount"},
+		"properties": fiber.Map{
+			"messages": fiber.Map{
+				"type":        "array",
+				"description": "The values passed to debug and stderr, in order",
+				"items": fiber.Map{
+					"type":     "object",
+					"required": []string{"type", "value"},
+					"properties": fiber.Map{
+						"type":  fiber.Map{"type": "string", "enum": []string{"debug", "stderr"}},
+						"value": fiber.Map{"description": "Any JSON value"},
+					},
+				},
+			},
+			"evalTimeMs":  fiber.Map{"type": "number"},
+			"resultCount": fiber.Map{"type": "integer", "description": "Values emitted by the filter"},
+			"truncated":   fiber.Map{"type": "boolean", "description": "Set if messages were dropped"},
+		},
+	}
+}
+
+func debugResultSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"result", "debug"},
+		"properties": fiber.Map{
+			"result": fiber.Map{"description": "The result of the filter, or the value passed to halt_error"},
+			"debug":  schemaRef("Debug"),
+		},
+	}
+}
+
+func filterErrorSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": fiber.Map{
+			"code":    fiber.Map{"type": "string", "enum": []string{string(goque.ErrFilterParse), string(goque.ErrFuncNotAllowed), string(goque.ErrFilterCompile)}},
+			"message": fiber.Map{"type": "string"},
+			"offset":  fiber.Map{"type": "integer"},
+			"line":    fiber.Map{"type": "integer"},
+			"column":  fiber.Map{"type": "integer"},
+		},
+	}
+}
+
+func validateRequestSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"filter"},
+		"properties": fiber.Map{
+			"filter": fiber.Map{"type": "string"},
+			"ast":    fiber.Map{"type": "boolean", "description": "Return the parsed filter as JSON"},
+			"format": fiber.Map{"type": "boolean", "description": "Return the filter in canonical form"},
+		},
+	}
+}
+
+func validateResponseSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"status", "valid"},
+		"properties": fiber.Map{
+			"status":    fiber.Map{"type": "string", "enum": []string{"ok", "error"}},
+			"valid":     fiber.Map{"type": "boolean"},
+			"ast":       fiber.Map{"type": "object"},
+			"formatted": fiber.Map{"type": "string"},
+			"message":   fiber.Map{"type": "string"},
+			"errors":    fiber.Map{"type": "array", "items": schemaRef("FilterError")},
+		},
+	}
+}
+
+func versionInfoSchema() fiber.Map {
+	return fiber.Map{
+		"type":     "object",
+		"required": []string{"version", "goVersion"},
+		"properties": fiber.Map{
+			"version":   fiber.Map{"type": "string"},
+			"commit":    fiber.Map{"type": "string"},
+			"buildTime": fiber.Map{"type": "string"},
+			"goVersion": fiber.Map{"type": "string"},
+		},
+	}
+}
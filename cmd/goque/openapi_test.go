@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func _headerParamNames(op fiber.Map) []string {
+	names := []string{}
+	for _, p := range op["parameters"].([]fiber.Map) {
+		names = append(names, p["name"].(string))
+	}
+	return names
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		spec := OpenAPISpec(_resetGetGoqueParamsFromStr([]string{"goque"}))
+		paths := spec["paths"].(fiber.Map)
+
+		assert.Len(t, paths, 3)
+		assert.Contains(t, paths, defaultPath+"/validate")
+		assert.Contains(t, paths, "/version")
+
+		op := paths[defaultPath].(fiber.Map)["post"].(fiber.Map)
+		assert.Equal(t, []string{"x-goque-jq-filter", "x-goque-jq-filter-encoding", "x-goque-sort-keys", "x-goque-indent", "x-goque-slurp", "x-goque-null-input", "Content-Encoding"}, _headerParamNames(op))
+		assert.Equal(t, true, op["parameters"].([]fiber.Map)[0]["required"])
+		assert.Equal(t, true, op["requestBody"].(fiber.Map)["required"])
+
+		responses := op["responses"].(fiber.Map)
+		for _, status := range []string{"200", "400", "403", "413", "415", "422", "500", "507"} {
+			assert.Contains(t, responses, status)
+		}
+		assert.NotContains(t, responses, "404")
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		spec := OpenAPISpec(_resetGetGoqueParamsFromStr([]string{"goque", "-a", "/peanuts", "-jq", ".pineapple", "-fp", "deny", "-dm", "true", "-ni", "true", "-hs", "0:200,7:409"}))
+		op := spec["paths"].(fiber.Map)["/peanuts"].(fiber.Map)["post"].(fiber.Map)
+
+		assert.Equal(t, []string{"x-goque-debug", "x-goque-sort-keys", "x-goque-indent", "x-goque-slurp", "x-goque-null-input", "Content-Encoding"}, _headerParamNames(op))
+		assert.Contains(t, op["description"], "`.pineapple`")
+		assert.Equal(t, false, op["requestBody"].(fiber.Map)["required"])
+		assert.Contains(t, op["responses"], "409")
+
+		ok := op["responses"].(fiber.Map)["200"].(fiber.Map)
+		assert.Contains(t, ok, "headers")
+		assert.Contains(t, ok["description"], "DebugResult")
+		assert.Contains(t, spec["components"].(fiber.Map)["schemas"], "DebugResult")
+	})
+}
+
+func TestOpenAPIContentEncodings(t *testing.T) {
+	spec := OpenAPISpec(_resetGetGoqueParamsFromStr([]string{"goque"}))
+	op := spec["paths"].(fiber.Map)[defaultPath].(fiber.Map)["post"].(fiber.Map)
+
+	var enum []string
+	for _, p := range op["parameters"].([]fiber.Map) {
+		if p["name"] == fiber.HeaderContentEncoding {
+			enum = p["schema"].(fiber.Map)["enum"].([]string)
+		}
+	}
+	assert.NotEmpty(t, enum)
+
+	documented := make(map[string]bool)
+	for _, encoding := range enum {
+		documented[encoding] = true
+	}
+
+	// Every encoding in the document decodes, every other one is rejected
+	body := []byte(`{"pineapple":true}`)
+	for _, encoding := range []string{"gzip", "x-gzip", "deflate", "br", "zstd", "identity", "compress", "x-compress", "exi", "pack200-gzip", "aes128gcm", "dcb", "dcz"} {
+		raw := body
+		if encoding != "identity" {
+			var err error
+			if raw, err = goque.CompressBody(body, strings.TrimPrefix(encoding, "x-")); err != nil {
+				raw = body
+			}
+		}
+
+		decoded, err := goque.DecompressBody(raw, encoding, 0)
+		if assert.Equal(t, documented[encoding], err == nil, encoding) && err == nil {
+			assert.Equal(t, body, decoded, encoding)
+		}
+	}
+}
+
+func TestOpenAPIRoute(t *testing.T) {
+	app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque"}))
+
+	res, err := app.Test(httptest.NewRequest("GET", defaultOASPath, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, fiber.MIMEApplicationJSON, res.Header.Get(fiber.HeaderContentType))
+
+	body, _ := io.ReadAll(res.Body)
+	var spec map[string]any
+	assert.NoError(t, json.Unmarshal(body, &spec))
+	assert.Equal(t, openAPIVersion, spec["openapi"])
+
+	app = NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-op", ""}))
+
+	res, err = app.Test(httptest.NewRequest("GET", defaultOASPath, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"embed"
+	"strings"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed playground/index.html
+var playgroundFS embed.FS
+
+// The playground settings sent to the page, see HandlePlaygroundConfig.
+type playgroundConfig struct {
+	Path          string `json:"path"`          // The jq route
+	ValidatePath  string `json:"validatePath"`  // The /validate route
+	HeaderFilters bool   `json:"headerFilters"` // Whether x-goque-jq-filter may be sent
+	DebugMode     bool   `json:"debugMode"`     // Whether x-goque-debug may be sent
+	Version       string `json:"version"`
+}
+
+// Adds the playground to app at p.playgroundPath: a page posting the
+// filter and input to the jq route as x-goque-jq-filter requests, so
+// results come from the server's own configuration. The page reads
+// its settings from config.json next to it.
+func AddPlayground(app *fiber.App, p *GoqueParams) {
+	index, err := playgroundFS.ReadFile("playground/index.html")
+	if err != nil {
+		panic(err)
+	}
+
+	base := strings.TrimSuffix(p.playgroundPath, "/")
+	config := playgroundConfig{
+		Path:          p.path,
+		ValidatePath:  validatePath(p),
+		HeaderFilters: p.engine.Config().RouteFilterPolicy(p.path) != goque.FilterPolicyDeny,
+		DebugMode:     p.engine.Config().DebugMode,
+		Version:       GetVersionInfo().Version,
+	}
+
+	sendIndex := func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(index)
+	}
+
+	app.Get(base, sendIndex)
+	app.Get(base+"/", sendIndex)
+	app.Get(base+"/config.json", func(c *fiber.Ctx) error {
+		return c.JSON(config)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlayground(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque"}))
+
+		res, err := app.Test(httptest.NewRequest("GET", "/playground", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 404, res.StatusCode)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-pg", "/playground/", "-a", "/peanuts", "-fp", "deny"}))
+
+		for _, path := range []string{"/playground", "/playground/"} {
+			res, err := app.Test(httptest.NewRequest("GET", path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, 200, res.StatusCode)
+			assert.Equal(t, fiber.MIMETextHTMLCharsetUTF8, res.Header.Get(fiber.HeaderContentType))
+
+			body, _ := io.ReadAll(res.Body)
+			assert.Contains(t, string(body), "goque playground")
+		}
+
+		res, err := app.Test(httptest.NewRequest("GET", "/playground/config.json", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+
+		var config playgroundConfig
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&config))
+		assert.Equal(t, "/peanuts", config.Path)
+		assert.Equal(t, "/peanuts/validate", config.ValidatePath)
+		assert.False(t, config.HeaderFilters)
+		assert.False(t, config.DebugMode)
+	})
+
+	// The playground sends filters header values can't hold
+	// percent-encoded, as written, so allowlisted hashes match
+	t.Run("multi-line filter", func(t *testing.T) {
+		filter := "{\"é\": .pineapple} # on pizza\n| keys"
+		app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque", "-pg", "/playground", "-fp", "allowlist", "-fa", goque.FilterHash(filter)}))
+
+		req := httptest.NewRequest("POST", defaultPath, strings.NewReader(`{"pineapple":true}`))
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("x-goque-jq-filter", url.PathEscape(filter))
+		req.Header.Set("x-goque-jq-filter-encoding", "percent")
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, `["é"]`, string(body))
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
+	"github.com/rs/zerolog"
+)
+
+// Exit codes of goque run, as in the jq CLI. Halts exit with the code
+// passed to halt_error.
+const (
+	exitOK      = 0
+	exitUsage   = 2 // Invalid arguments or unreadable input
+	exitCompile = 3 // The filter failed to parse or compile
+	exitError   = 5 // The filter failed or a limit was exceeded
+)
+
+// The log level of goque run and bench, so logs don't drown the
+// results. GOQUE_LOG_LEVEL and -l still apply.
+const defaultRunLogLevel = zerolog.WarnLevel
+
+// Runs a filter over JSON read from files or stdin and writes the
+// result to stdout, returning the exit code. args are the arguments
+// after "run": the flags of runFlags, then the filter and input files.
+// The filter is taken from -jq or GOQUE_JQ_FILTER if set, in which
+// case every argument is a file.
+//
+// The filter runs exactly as the server would run it on a request
+// holding the input: the same configuration, custom functions,
+// function policy, limits, input modes and output format. The first
+// value is the input, the rest are read with input and inputs, and
+// the first non-null result is written. Inputs are parsed like request
+// bodies with the Content-Type set by -ct, see readRunInputs. debug and
+// stderr write to stderr.
+func RunCLI(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("run", "[flags] [filter] [file...]", stderr)
+	raw := fs.Bool("r", false, "Write string results without quotes")
+
+	in, exitCode := loadRunInput(fs, args, stdin, stderr)
+	if in == nil {
+		return exitCode
+	}
+	gp := in.gp
+
+	code, err := in.compile(stderr)
+	if err != nil {
+		return runError(stderr, err, exitCompile)
+	}
+
+	out, err := gp.engine.Evaluate(code, in.body)
+	if h, ok := err.(*goque.HaltError); ok {
+		writeHaltValue(stderr, h.Value)
+		return h.ExitCode
+	} else if err != nil {
+		return runError(stderr, err, exitError)
+	}
+
+	if s, ok := out.(string); ok && *raw {
+		fmt.Fprintln(stdout, s)
+		return exitOK
+	}
+
+	result, err := formatRunResult(gp, out)
+	if err != nil {
+		return runError(stderr, err, exitError)
+	}
+
+	fmt.Fprintln(stdout, string(result))
+	return exitOK
+}
+
+// A filter and its input values, loaded by run and bench.
+type runInput struct {
+	gp     *GoqueParams
+	filter string
+	body   any   // The filter input, null with null input
+	inputs []any // The values read with input and inputs
+}
+
+// Loads the configuration from args parsed with fs, then the filter
+// and its input values from the remaining args and stdin. Returns nil
+// and the exit code if loading failed.
+func loadRunInput(fs *flag.FlagSet, args []string, stdin io.Reader, stderr io.Writer) (*runInput, int) {
+	config := GetDefaultConfiguration()
+	config["logLevel"].val = defaultRunLogLevel.String()
+	contentType := fs.String("ct", "", "Content-Type of the input, default XML for .xml files and input starting with <, else JSON")
+
+	setEnvs, _, err := parseCommandConfiguration(fs, args, config, runFlags)
+	if err != nil {
+		return nil, configExitCode(err, stderr)
+	}
+
+	in := &runInput{filter: takeFilter(config)}
+	if in.gp = loadGoqueParams(setEnvs, config, stderr); in.gp == nil {
+		return nil, exitUsage
+	}
+
+	files := fs.Args()
+	if in.filter == "" {
+		if len(files) == 0 {
+			fs.Usage()
+			return nil, exitUsage
+		}
+		in.filter, files = files[0], files[1:]
+	}
+
+	values, err := readRunInputs(in.gp, stdin, files, *contentType)
+	if err != nil {
+		return nil, runError(stderr, err, exitUsage)
+	}
+
+	in.inputs = values
+	if !in.gp.engine.Config().NullInput {
+		if len(values) == 0 {
+			return nil, runError(stderr, goque.NewProblem(goque.ErrInvalidBody, "No input"), exitUsage)
+		}
+		in.body, in.inputs = values[0], values[1:]
+	}
+
+	return in, exitOK
+}
+
+// Compiles the filter like a request would, with input and inputs
+// reading the remaining values and debug and stderr writing to w.
+func (in *runInput) compile(w io.Writer) (*gojq.Code, error) {
+	options := append(stderrDebugOptions(w), gojq.WithInputIter(gojq.NewIter(in.inputs...)))
+
+	_, code, err := in.gp.engine.CompileWith(in.filter, options...)
+	return code, err
+}
+
+// Formats a result in the output format of p, checking the output
+// size limit.
+func formatRunResult(p *GoqueParams, out any) ([]byte, error) {
+	return p.engine.Format(out, p.engine.OutputFormat())
+}
+
+// An input of goque run, read from a file or stdin.
+type runSource struct {
+	name string
+	data []byte
+}
+
+// Reads the values of every file, or of stdin if there are none. Each
+// input is parsed like a request body sent with contentType, see
+// goque.Engine.ParseBody, so it is decoded and limited as the server
+// would. Without contentType, .xml files and inputs starting with <
+// are XML, anything else is JSON. A single input is slurped like a
+// body, several are slurped into one array. Empty inputs are skipped.
+func readRunInputs(p *GoqueParams, stdin io.Reader, files []string, contentType string) ([]any, error) {
+	var sources []runSource
+
+	if len(files) == 0 {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, runSource{"stdin", data})
+	}
+
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, runSource{name, data})
+	}
+
+	slurp := p.engine.Config().Slurp
+	var values []any
+	for _, src := range sources {
+		if len(src.data) == 0 {
+			continue
+		}
+
+		decoded, err := p.engine.ParseBody(src.data, runContentType(src, contentType), "", slurp && len(sources) == 1)
+		if err != nil {
+			return nil, inputError(src.name, err)
+		}
+		values = append(values, decoded...)
+	}
+
+	if slurp && (len(sources) > 1 || values == nil) {
+		if values == nil {
+			values = []any{}
+		}
+		return []any{values}, nil
+	}
+
+	return values, nil
+}
+
+// Returns contentType, or the Content-Type of src guessed from its
+// name and first byte if empty.
+func runContentType(src runSource, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+
+	data := bytes.TrimLeft(src.data, " \t\r\n")
+	if strings.EqualFold(filepath.Ext(src.name), ".xml") || len(data) > 0 && data[0] == '<' {
+		return fiber.MIMEApplicationXML
+	}
+
+	return fiber.MIMEApplicationJSON
+}
+
+// Names the input in invalid body errors.
+func inputError(name string, err error) error {
+	if problem, ok := err.(*goque.Problem); ok && problem.Code == goque.ErrInvalidBody {
+		named := *problem
+		named.Detail = name + ": " + problem.Detail
+		return &named
+	}
+
+	return err
+}
+
+// debug and stderr writing to w, as in the jq CLI.
+func stderrDebugOptions(w io.Writer) []gojq.CompilerOption {
+	return []gojq.CompilerOption{
+		gojq.WithFunction("debug", 0, 0, func(v any, _ []any) any {
+			if raw, err := goque.JSONAPI(false, false).Marshal([]any{"DEBUG:", v}); err == nil {
+				fmt.Fprintln(w, string(raw))
+			}
+			return v
+		}),
+		gojq.WithFunction("stderr", 0, 0, func(v any, _ []any) any {
+			if raw, err := goque.JSONAPI(false, false).Marshal(v); err == nil {
+				fmt.Fprint(w, string(raw))
+			}
+			return v
+		}),
+	}
+}
+
+// Writes a halt_error value like the jq CLI: strings as is, anything
+// else as JSON on its own line. halt writes nothing.
+func writeHaltValue(w io.Writer, v any) {
+	if s, ok := v.(string); ok {
+		fmt.Fprint(w, s)
+	} else if v != nil {
+		if raw, err := goque.JSONAPI(false, false).Marshal(v); err == nil {
+			fmt.Fprintln(w, string(raw))
+		}
+	}
+}
+
+// Writes err to w as "goque: <code>: <detail>" and returns exitCode.
+func runError(w io.Writer, err error, exitCode int) int {
+	problem := goque.AsProblem(err)
+	if problem.Code == goque.ErrInternal {
+		fmt.Fprintln(w, "goque: "+err.Error())
+	} else {
+		fmt.Fprintln(w, "goque: "+string(problem.Code)+": "+problem.Detail)
+	}
+
+	return exitCode
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCLI(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{"result", []string{".peanuts"}, `{"peanuts":true}`, exitOK, "true\n", ""},
+		{"first non-null result", []string{"null, .a, .b"}, `{"b":2}`, exitOK, "2\n", ""},
+		{"inputs", []string{"[., inputs]"}, "1\n2\n3", exitOK, "[1,2,3]\n", ""},
+		{"big numbers", []string{"."}, `12345678901234567890`, exitOK, "12345678901234567890\n", ""},
+		{"custom functions", []string{`"peanuts" | sha256`}, `null`, exitOK, `"487ba2299be7f759d7c7bf6a4ac3a32cee81f1bb9332fc485947e32918864fb2"` + "\n", ""},
+		{"raw", []string{"-r", ".a"}, `{"a":"pineapple"}`, exitOK, "pineapple\n", ""},
+		{"format", []string{"-sk", "true", "-in", "1", "."}, `{"b":1,"a":2}`, exitOK, "{\n \"a\": 2,\n \"b\": 1\n}\n", ""},
+		{"slurp", []string{"-sl", "true", "length"}, "1 2 3", exitOK, "3\n", ""},
+		{"null input", []string{"-ni", "true", "[inputs]"}, "1 2", exitOK, "[1,2]\n", ""},
+		{"debug", []string{". | debug"}, `1`, exitOK, "1\n", `["DEBUG:",1]` + "\n"},
+		{"halt_error", []string{`"pineapple\n" | halt_error(4)`}, `1`, 4, "", "pineapple\n"},
+		{"runtime error", []string{".a"}, `1`, exitError, "", "goque: filter_runtime_error: expected an object but got: number (1)\n"},
+		{"parse error", []string{".a |"}, `1`, exitCompile, "", "goque: filter_parse_error: unexpected EOF\n"},
+		{"denied function", []string{"-fd", "now", "now"}, `1`, exitCompile, "", "goque: function_not_allowed: function not allowed: now/0 at line 1, column 1\n"},
+		{"invalid input", []string{"."}, `{`, exitUsage, "", "goque: invalid_body: stdin: ReadMapCB: expect \" after {, but found \x00, error found in #1 byte of ...|{|..., bigger context ...|{|...\n"},
+		{"xml stdin", []string{".a.b"}, `<a><b>1</b></a>`, exitOK, "\"1\"\n", ""},
+		{"content type", []string{"-ct", "application/x-ndjson", "[., input]"}, "{\"a\":1}\n{\"a\":2}\n", exitOK, `[{"a":1},{"a":2}]` + "\n", ""},
+		{"unsupported content type", []string{"-ct", "text/plain", "."}, `1`, exitUsage, "", "goque: unsupported_media_type: Unsupported Content-Type: text/plain\n"},
+		{"no input", []string{"."}, ``, exitUsage, "", "goque: invalid_body: No input\n"},
+		{"body limit", []string{"-md", "1", "."}, `[[1]]`, exitUsage, "", "goque: body_limit_exceeded: Nesting depth exceeds the limit of 1 at [0]\n"},
+		{"result limit", []string{"-mr", "1", "null, null"}, `1`, exitError, "", "goque: result_limit_exceeded: Filter emitted more than 1 results\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+
+			var stdout, stderr bytes.Buffer
+			exitCode := RunCLI(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			assert.Equal(t, tt.exitCode, exitCode)
+			assert.Equal(t, tt.stdout, stdout.String())
+			if tt.stderr != "" {
+				assert.Equal(t, tt.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunCLIFiles(t *testing.T) {
+	os.Clearenv()
+
+	dir := t.TempDir()
+	json := filepath.Join(dir, "peanuts.json")
+	xml := filepath.Join(dir, "pineapple.xml")
+	os.WriteFile(json, []byte(`{"peanuts":1} {"peanuts":2}`), 0o644)
+	os.WriteFile(xml, []byte(`<pineapple><count>3</count></pineapple>`), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	exitCode := RunCLI([]string{"-jq", "[., inputs]", json, xml}, strings.NewReader(""), &stdout, &stderr)
+
+	assert.Equal(t, exitOK, exitCode)
+	assert.Equal(t, `[{"peanuts":1},{"peanuts":2},{"pineapple":{"count":"3"}}]`+"\n", stdout.String())
+
+	exitCode = RunCLI([]string{".", filepath.Join(dir, "missing.json")}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, exitUsage, exitCode)
+}
+
+// goque run prints what the server sends for the same filter and body.
+func TestRunCLIMatchesServer(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		contentType string
+		body        string
+	}{
+		{"json", []string{"-sk", "true"}, "application/json", `{"b":12345678901234567890,"a":[1.50,1e3]}`},
+		{"stream", nil, "application/x-ndjson", "{\"a\":1}\n{\"a\":2}\n"},
+		{"slurp", []string{"-sl", "true"}, "application/json", "1 2 3"},
+		{"xml", []string{"-sk", "true"}, "application/xml", `<order id="7"><line>2</line><line>3</line></order>`},
+		{"escaped html", []string{"-e", "true"}, "application/json", `"<b>&</b>"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			filter := "[., inputs]"
+
+			var stdout, stderr bytes.Buffer
+			exitCode := RunCLI(append(append([]string{"-ct", tt.contentType}, tt.args...), filter), strings.NewReader(tt.body), &stdout, &stderr)
+			assert.Equal(t, exitOK, exitCode, stderr.String())
+
+			app := NewApp(_resetGetGoqueParamsFromStr(append([]string{"goque"}, tt.args...)))
+			req := httptest.NewRequest("POST", defaultPath, strings.NewReader(tt.body))
+			req.Header.Set("content-type", tt.contentType)
+			req.Header.Set("x-goque-jq-filter", filter)
+
+			res, err := app.Test(req)
+			assert.NoError(t, err)
+			resBody, _ := io.ReadAll(res.Body)
+
+			assert.Equal(t, string(resBody)+"\n", stdout.String())
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/rs/zerolog/log"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/sdk/resource"
 
@@ -13,6 +14,8 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
+// Initializes the Jaeger trace provider. Spans carry the service name
+// and version, commit and build time of goque, see GetVersionInfo.
 func InitTracer(ratio float64, endpoint string) *sdktrace.TracerProvider {
 	// Create a new
 	exporter, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint))) // default endpoint: "http://localhost:14268/api/traces"
@@ -21,6 +24,8 @@ func InitTracer(ratio float64, endpoint string) *sdktrace.TracerProvider {
 		log.Fatal().AnErr("initTracer", err).Msg("")
 	}
 
+	info := GetVersionInfo()
+
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(ratio)),
 		sdktrace.WithBatcher(exporter),
@@ -28,6 +33,9 @@ func InitTracer(ratio float64, endpoint string) *sdktrace.TracerProvider {
 			resource.NewWithAttributes(
 				semconv.SchemaURL,
 				semconv.ServiceNameKey.String("goque"),
+				semconv.ServiceVersionKey.String(info.Version),
+				attribute.String("goque.commit", info.Commit),
+				attribute.String("goque.build_time", info.BuildTime),
 			)),
 	)
 	otel.SetTracerProvider(tp)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/Max-Clark/goque/pkg/goque"
+)
+
+// Checks each filter in args, or the configured filter if there are
+// none, like the /validate route. Invalid filters are written to stderr
+// with their location and exit 3. With -format or -ast valid filters
+// are written to stdout in canonical form or parsed as JSON.
+func validateCommand(args []string, _ io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := newFlagSet("validate", "[flags] [filter...]", stderr)
+	files := fs.Bool("f", false, "Read the filters from the files given as arguments")
+	format := fs.Bool("format", false, "Write valid filters in canonical form")
+	ast := fs.Bool("ast", false, "Write valid filters parsed as JSON")
+
+	config := GetDefaultConfiguration()
+	config["logLevel"].val = defaultRunLogLevel.String()
+
+	setEnvs, _, err := parseCommandConfiguration(fs, args, config, validateFlags)
+	if err != nil {
+		return configExitCode(err, stderr)
+	}
+
+	type source struct{ name, filter string }
+	var sources []source
+	if filter := takeFilter(config); filter != "" {
+		sources = append(sources, source{"GOQUE_JQ_FILTER", filter})
+	}
+
+	for i, arg := range fs.Args() {
+		if !*files {
+			sources = append(sources, source{"filter " + strconv.Itoa(i+1), arg})
+			continue
+		}
+
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(stderr, "goque: "+err.Error())
+			return exitUsage
+		}
+		sources = append(sources, source{arg, string(data)})
+	}
+
+	if len(sources) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	gp := loadGoqueParams(setEnvs, config, stderr)
+	if gp == nil {
+		return exitUsage
+	}
+
+	exitCode := exitOK
+	for _, src := range sources {
+		query, _, err := gp.engine.Compile(src.filter)
+		if err != nil {
+			e := err.(*goque.FilterError)
+			fmt.Fprintf(stderr, "%s:%d:%d: %s: %s\n", src.name, e.Line, e.Column, e.Code, e.Message)
+			exitCode = exitCompile
+			continue
+		}
+
+		if *format {
+			fmt.Fprintln(stdout, query.String())
+		}
+		if *ast {
+			raw, _ := goque.JSONAPI(false, true).Marshal(goque.ASTJSON(query))
+			fmt.Fprintln(stdout, string(raw))
+		}
+	}
+
+	return exitCode
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Build info, set with
+//
+//	-ldflags "-X main.Version=v1.0.0 -X main.Commit=$(git rev-parse HEAD) -X main.BuildTime=$(date -u +%FT%TZ)"
+//
+// Unset values are read from the Go build info, see GetVersionInfo.
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+// The version of the running goque.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Returns the version set at build time. Unset values fall back to
+// the build info: the module version for go install builds, the VCS
+// revision and the commit time as build time. The version is "dev"
+// if neither has one.
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+
+	return info
+}
+
+// Returns e.g. "goque v1.0.0 (commit 0123abc, built 2023-03-01T00:00:00Z, go1.20)".
+func (v VersionInfo) String() string {
+	details := []string{}
+	if v.Commit != "" {
+		details = append(details, "commit "+shortCommit(v.Commit))
+	}
+	if v.BuildTime != "" {
+		details = append(details, "built "+v.BuildTime)
+	}
+	details = append(details, v.GoVersion)
+
+	return "goque " + v.Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+
+	return commit
+}
+
+// The handler for version requests, sends GetVersionInfo as JSON.
+func HandleVersion(c *fiber.Ctx) error {
+	return c.JSON(GetVersionInfo())
+}
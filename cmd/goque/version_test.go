@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetVersionInfo(t *testing.T) {
+	defer func(version, commit, buildTime string) {
+		Version, Commit, BuildTime = version, commit, buildTime
+	}(Version, Commit, BuildTime)
+
+	Version, Commit, BuildTime = "v1.2.3", "0123456789abcdef", "2023-03-01T00:00:00Z"
+
+	info := GetVersionInfo()
+	assert.Equal(t, VersionInfo{"v1.2.3", "0123456789abcdef", "2023-03-01T00:00:00Z", runtime.Version()}, info)
+	assert.Equal(t, "goque v1.2.3 (commit 0123456, built 2023-03-01T00:00:00Z, "+runtime.Version()+")", info.String())
+
+	// Test binaries have no module version
+	Version = ""
+	assert.NotEmpty(t, GetVersionInfo().Version)
+}
+
+func TestHandleVersion(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v1.2.3"
+
+	app := NewApp(_resetGetGoqueParamsFromStr([]string{"goque"}))
+
+	res, err := app.Test(httptest.NewRequest("GET", "/version", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	body, _ := io.ReadAll(res.Body)
+	assert.Contains(t, string(body), `"version":"v1.2.3"`)
+	assert.Contains(t, string(body), `"goVersion":"`+runtime.Version()+`"`)
+}
@@ -0,0 +1,78 @@
+package goque
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/itchyny/gojq"
+)
+
+// A least recently used cache of compiled filters by source, so
+// clients sending the same header filter don't compile it on every
+// request. A nil cache holds nothing.
+type filterCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Of *cachedFilter, most recently used first
+	entries map[string]*list.Element
+}
+
+type cachedFilter struct {
+	filter string
+	code   *gojq.Code
+}
+
+func newFilterCache(size int) *filterCache {
+	return &filterCache{size: size, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+func (c *filterCache) get(filter string) (*gojq.Code, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[filter]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedFilter).code, true
+}
+
+// Adds the code of filter, evicting the least recently used filter
+// once the cache is full.
+func (c *filterCache) add(filter string, code *gojq.Code) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[filter]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[filter] = c.order.PushFront(&cachedFilter{filter, code})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedFilter).filter)
+	}
+}
+
+func (c *filterCache) len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
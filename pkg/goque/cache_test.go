@@ -0,0 +1,41 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterCache(t *testing.T) {
+	cache := newFilterCache(2)
+	a := _compileJQCode(t, ".a", nil)
+	b := _compileJQCode(t, ".b", nil)
+	c := _compileJQCode(t, ".c", nil)
+
+	cache.add(".a", a)
+	cache.add(".b", b)
+
+	// .a is used last, so .b is evicted
+	code, ok := cache.get(".a")
+	assert.True(t, ok)
+	assert.Same(t, a, code)
+
+	cache.add(".c", c)
+	assert.Equal(t, 2, cache.len())
+
+	_, ok = cache.get(".b")
+	assert.False(t, ok)
+
+	code, ok = cache.get(".c")
+	assert.True(t, ok)
+	assert.Same(t, c, code)
+}
+
+func TestFilterCacheNil(t *testing.T) {
+	var cache *filterCache
+
+	cache.add(".a", _compileJQCode(t, ".a", nil))
+	_, ok := cache.get(".a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.len())
+}
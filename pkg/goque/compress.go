@@ -0,0 +1,254 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Responses smaller than this are not worth compressing.
+const compressMinSize = 256
+
+// Supported content encodings in order of server preference.
+var supportedEncodings = []string{"zstd", "br", "gzip", "deflate"}
+
+// Content encodings DecompressBody undoes, see newDecompressReader.
+var decodableEncodings = []string{"gzip", "x-gzip", "deflate", "br", "zstd", "identity"}
+
+// Returns the content encodings request bodies may be sent with.
+func DecodableEncodings() []string {
+	return append([]string(nil), decodableEncodings...)
+}
+
+// Decompresses body according to the Content-Encoding header. Stacked
+// encodings (e.g. "gzip, br") are undone in reverse order. The
+// decompressed size is capped at limit bytes to guard against zip
+// bombs, 0 is unlimited. Returned errors are a *Problem with a 413,
+// 415 or 400 status.
+func DecompressBody(body []byte, contentEncoding string, limit int64) ([]byte, error) {
+	encodings := strings.Split(contentEncoding, ",")
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		r, err := newDecompressReader(encoding, body)
+		if err != nil {
+			return nil, err
+		}
+
+		var limited io.Reader = r
+		if limit > 0 {
+			limited = io.LimitReader(r, limit+1)
+		}
+
+		body, err = io.ReadAll(limited)
+		r.Close()
+
+		if err != nil {
+			return nil, NewProblem(ErrInvalidBody, "Could not decompress "+encoding+" body: "+err.Error())
+		}
+
+		if limit > 0 && int64(len(body)) > limit {
+			return nil, NewProblem(ErrBodyTooLarge,
+				"Decompressed body exceeds the limit of "+strconv.FormatInt(limit, 10)+" bytes")
+		}
+	}
+
+	return body, nil
+}
+
+// Returns a reader that decompresses body with the given encoding.
+func newDecompressReader(encoding string, body []byte) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	var err error
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// HTTP deflate is zlib wrapped, but some clients send raw deflate
+		r, err = zlib.NewReader(bytes.NewReader(body))
+		if err == zlib.ErrHeader {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "br":
+		r = io.NopCloser(brotli.NewReader(bytes.NewReader(body)))
+	case "zstd":
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			r = d.IOReadCloser()
+		}
+	default:
+		return nil, NewProblem(ErrUnsupportedEncoding, "Unsupported Content-Encoding: "+encoding)
+	}
+
+	if err != nil {
+		return nil, NewProblem(ErrInvalidBody, "Could not decompress "+encoding+" body: "+err.Error())
+	}
+
+	return r, nil
+}
+
+// Compresses body with one of the supported encodings.
+func CompressBody(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	default:
+		err = fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Picks the response encoding from an Accept-Encoding header. The
+// highest q-value wins, ties are broken by supportedEncodings order.
+// Returns "" if no supported encoding is acceptable.
+func NegotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if name == "*" {
+			wildcard = q
+		} else {
+			qualities[name] = q
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// Middleware compressing responses with the encoding negotiated from
+// Accept-Encoding. Small or already encoded responses are left as is.
+// See CompressHTTPResponse for net/http.
+func CompressResponse(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	c.Vary(fiber.HeaderAcceptEncoding)
+
+	body := c.Response().Body()
+	if len(body) < compressMinSize || len(c.Response().Header.Peek(fiber.HeaderContentEncoding)) != 0 {
+		return nil
+	}
+
+	encoding := NegotiateEncoding(c.Get(fiber.HeaderAcceptEncoding))
+	if encoding == "" {
+		return nil
+	}
+
+	compressed, err := CompressBody(body, encoding)
+	if err != nil {
+		return err
+	}
+
+	c.Response().SetBodyRaw(compressed)
+	c.Set(fiber.HeaderContentEncoding, encoding)
+
+	return nil
+}
+
+// Wraps a net/http handler, e.g. HTTPHandler, to compress its responses
+// like CompressResponse. Responses are buffered until h returns, and
+// sent as is if compressing fails.
+func CompressHTTPResponse(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(res, r)
+
+		if !strings.Contains(strings.ToLower(strings.Join(w.Header().Values(fiber.HeaderVary), ",")), "accept-encoding") {
+			w.Header().Add(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+		}
+
+		body := res.body.Bytes()
+		if len(body) >= compressMinSize && w.Header().Get(fiber.HeaderContentEncoding) == "" {
+			if encoding := NegotiateEncoding(r.Header.Get(fiber.HeaderAcceptEncoding)); encoding != "" {
+				if compressed, err := CompressBody(body, encoding); err == nil {
+					body = compressed
+					w.Header().Set(fiber.HeaderContentEncoding, encoding)
+					w.Header().Del(fiber.HeaderContentLength)
+				}
+			}
+		}
+
+		w.WriteHeader(res.status)
+		w.Write(body)
+	})
+}
+
+// A response written by a handler wrapped by CompressHTTPResponse.
+// Headers go to the wrapped writer, the status and body are kept.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
@@ -0,0 +1,81 @@
+package goque
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressBody(t *testing.T) {
+	payload := []byte(`{"peanuts":true,"pineapple":"nope."}`)
+
+	for _, encoding := range supportedEncodings {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := CompressBody(payload, encoding)
+			assert.NoError(t, err)
+
+			got, err := DecompressBody(compressed, strings.ToUpper(encoding), 1024)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, got)
+		})
+	}
+
+	t.Run("stacked", func(t *testing.T) {
+		gzipped, _ := CompressBody(payload, "gzip")
+		compressed, _ := CompressBody(gzipped, "br")
+
+		got, err := DecompressBody(compressed, "gzip, br", 1024)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, got)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		got, err := DecompressBody(payload, "", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, got)
+	})
+
+	t.Run("zip bomb", func(t *testing.T) {
+		compressed, _ := CompressBody(bytes.Repeat([]byte(" "), 1<<20), "gzip")
+
+		_, err := DecompressBody(compressed, "gzip", 1024)
+		assert.Error(t, err)
+		assert.Equal(t, fiber.StatusRequestEntityTooLarge, err.(*Problem).Status)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := DecompressBody(payload, "compress", 1024)
+		assert.Error(t, err)
+		assert.Equal(t, fiber.StatusUnsupportedMediaType, err.(*Problem).Status)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		_, err := DecompressBody(payload, "gzip", 1024)
+		assert.Error(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, err.(*Problem).Status)
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.1, gzip;q=0", "zstd"},
+		{"zstd;q=0, *", "br"},
+		{"compress", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equalf(t, tt.want, NegotiateEncoding(tt.acceptEncoding), "Accept-Encoding: %q", tt.acceptEncoding)
+	}
+}
@@ -0,0 +1,355 @@
+package goque
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// A request sent to both the fiber and the net/http handler, and the
+// response both must send.
+type conformanceCase struct {
+	name      string
+	configure func(*Config)
+	headers   map[string]string
+	body      string
+
+	wantStatus  int
+	wantType    string            // Content-Type, unchecked if empty
+	wantHeaders map[string]string // Other response headers
+	wantBody    string
+	contains    bool // wantBody is a part of the body, e.g. with timings
+	compress    bool // Serve with response compression
+}
+
+// Serves req with the fiber handler of e, mounted as the goque server
+// does.
+func _serveFiber(t *testing.T, e *Engine, req *http.Request, compress bool) *http.Response {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true, ErrorHandler: e.HandleError})
+	if compress {
+		app.Use(CompressResponse)
+	}
+	app.Post("/jq", e.Handler())
+
+	res, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	return res
+}
+
+// Serves a request with the net/http handler of e.
+func _serveHTTP(t *testing.T, e *Engine, req *http.Request, compress bool) *http.Response {
+	h := e.HTTPHandler()
+	if compress {
+		h = CompressHTTPResponse(h)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func _gzip(t *testing.T, s string) string {
+	compressed, err := CompressBody([]byte(s), "gzip")
+	assert.NoError(t, err)
+	return string(compressed)
+}
+
+func TestHandlerConformance(t *testing.T) {
+	body := `{"peanuts":true,"pineapple":"nope."}`
+	problem := func(status, code, title, detail string) string {
+		return `{"type":"urn:goque:problem:` + code + `","title":"` + title + `","status":` + status + `,"detail":"` + detail + `","code":"` + code + `"}`
+	}
+
+	numbers := make([]string, 100)
+	for i := range numbers {
+		numbers[i] = strconv.Itoa(i)
+	}
+	rangeJSON := "[" + strings.Join(numbers, ",") + "]"
+
+	tests := []conformanceCase{
+		{
+			name:       "header filter",
+			headers:    map[string]string{"x-goque-jq-filter": ".peanuts"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `true`,
+		},
+		{
+			name:       "configured filter",
+			configure:  func(c *Config) { c.Filter = ".pineapple" },
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `"nope."`,
+		},
+		{
+			name:       "missing filter",
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("400", "missing_filter", "Missing filter", "A JQ filter was not sent with request"),
+		},
+		{
+			name:       "parse error",
+			headers:    map[string]string{"x-goque-jq-filter": "(wut"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: `{"type":"urn:goque:problem:filter_parse_error","title":"Filter parse error","status":400,"detail":"unexpected EOF","code":"filter_parse_error","offset":4,"line":1,"column":5}`,
+		},
+		{
+			name:       "runtime error",
+			headers:    map[string]string{"x-goque-jq-filter": ".peanuts[]"},
+			body:       body,
+			wantStatus: fiber.StatusUnprocessableEntity, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("422", "filter_runtime_error", "Filter runtime error", "cannot iterate over: boolean (true)"),
+		},
+		{
+			name:       "function not allowed",
+			configure:  func(c *Config) { c.FuncDeny = ParseFuncList("debug") },
+			headers:    map[string]string{"x-goque-jq-filter": ".peanuts | debug"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: `{"type":"urn:goque:problem:function_not_allowed","title":"Function not allowed","status":400,"detail":"function not allowed: debug/0 at line 1, column 12","code":"function_not_allowed","offset":11,"line":1,"column":12}`,
+		},
+		{
+			name:       "filter forbidden",
+			configure:  func(c *Config) { c.FilterPolicy = FilterPolicyDeny },
+			headers:    map[string]string{"x-goque-jq-filter": ".peanuts"},
+			body:       body,
+			wantStatus: fiber.StatusForbidden, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("403", "filter_forbidden", "Filter forbidden", "Header filters are not allowed"),
+		},
+		{
+			name:       "named filter",
+			configure:  func(c *Config) { c.NamedFilters = map[string]string{"pineapple": ".pineapple"} },
+			headers:    map[string]string{"x-goque-filter-name": "pineapple"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `"nope."`,
+		},
+		{
+			name: "route policy",
+			configure: func(c *Config) {
+				c.RoutePolicies = map[string]RoutePolicy{"/jq": {FilterPolicy: FilterPolicyDeny}}
+			},
+			headers:    map[string]string{"x-goque-jq-filter": ".peanuts"},
+			body:       body,
+			wantStatus: fiber.StatusForbidden, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("403", "filter_forbidden", "Filter forbidden", "Header filters are not allowed"),
+		},
+		{
+			name:       "unsupported media type",
+			headers:    map[string]string{"content-type": "text/plain", "x-goque-jq-filter": "."},
+			body:       body,
+			wantStatus: fiber.StatusUnsupportedMediaType, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("415", "unsupported_media_type", "Unsupported media type", "Unsupported Content-Type: text/plain"),
+		},
+		{
+			name:       "body too large",
+			configure:  func(c *Config) { c.BodyLimit = 16 },
+			headers:    map[string]string{"x-goque-jq-filter": "."},
+			body:       body,
+			wantStatus: fiber.StatusRequestEntityTooLarge, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("413", "body_too_large", "Request body too large", "Request body exceeds the limit of 16 bytes"),
+		},
+		{
+			name:       "compressed body",
+			headers:    map[string]string{"content-encoding": "gzip", "x-goque-jq-filter": ".pineapple"},
+			body:       _gzip(t, body),
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `"nope."`,
+		},
+		{
+			name:       "compressed response",
+			headers:    map[string]string{"accept-encoding": "br;q=0.5, gzip", "x-goque-jq-filter": "[range(100)]"},
+			body:       body,
+			compress:   true,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON,
+			wantHeaders: map[string]string{"content-encoding": "gzip", "vary": "Accept-Encoding"},
+			wantBody:    _gzip(t, rangeJSON),
+		},
+		{
+			name:       "small response not compressed",
+			headers:    map[string]string{"accept-encoding": "gzip", "x-goque-jq-filter": ".peanuts"},
+			body:       body,
+			compress:   true,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON,
+			wantHeaders: map[string]string{"content-encoding": "", "vary": "Accept-Encoding"},
+			wantBody:    `true`,
+		},
+		{
+			name:       "response compression not accepted",
+			headers:    map[string]string{"x-goque-jq-filter": "[range(100)]"},
+			body:       body,
+			compress:   true,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON,
+			wantHeaders: map[string]string{"content-encoding": ""},
+			wantBody:    rangeJSON,
+		},
+		{
+			name:       "xml body",
+			headers:    map[string]string{"content-type": "application/xml", "x-goque-jq-filter": ".test.pineapple"},
+			body:       `<test><peanuts>true</peanuts><pineapple>nope.</pineapple></test>`,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `"nope."`,
+		},
+		{
+			name:       "xml result",
+			headers:    map[string]string{"accept": "text/html, application/xml;q=0.9", "x-goque-jq-filter": "{test: .}"},
+			body:       `{"peanuts":true}`,
+			wantStatus: fiber.StatusOK, wantType: "application/xml; charset=utf-8", wantBody: `<test><peanuts>true</peanuts></test>`,
+		},
+		{
+			name:       "xml result by range",
+			headers:    map[string]string{"accept": "text/*", "x-goque-jq-filter": "{test: .peanuts}"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: "text/xml; charset=utf-8", wantBody: `<test>true</test>`,
+		},
+		{
+			name:       "output format",
+			headers:    map[string]string{"x-goque-jq-filter": "{b: .peanuts, a: [1]}", "x-goque-sort-keys": "true", "x-goque-indent": "1"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: "{\n \"a\": [\n  1\n ],\n \"b\": true\n}",
+		},
+		{
+			// "{\"é\": .pineapple} # on pizza\n| keys"
+			name:       "percent-encoded filter",
+			headers:    map[string]string{"x-goque-jq-filter": "%7B%22%C3%A9%22%3A%20.pineapple%7D%20%23%20on%20pizza%0A%7C%20keys", "x-goque-jq-filter-encoding": "percent"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `["é"]`,
+		},
+		{
+			name:       "invalid filter encoding",
+			headers:    map[string]string{"x-goque-jq-filter": ".%ZZ", "x-goque-jq-filter-encoding": "percent"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("400", "invalid_request", "Invalid request", "x-goque-jq-filter is not percent-encoded UTF-8"),
+		},
+		{
+			name:       "unknown filter encoding",
+			headers:    map[string]string{"x-goque-jq-filter": ".", "x-goque-jq-filter-encoding": "base64"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("400", "invalid_request", "Invalid request", "x-goque-jq-filter-encoding must be percent"),
+		},
+		{
+			name:       "invalid output format",
+			headers:    map[string]string{"x-goque-jq-filter": ".", "x-goque-indent": "8"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("400", "invalid_request", "Invalid request", "x-goque-indent must be 0-7 or tab"),
+		},
+		{
+			name:       "output too large",
+			configure:  func(c *Config) { c.MaxOutputSize = 4 },
+			headers:    map[string]string{"x-goque-jq-filter": ".pineapple"},
+			body:       body,
+			wantStatus: fiber.StatusInsufficientStorage, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("507", "output_limit_exceeded", "Output limit exceeded", "Result exceeds the output limit of 4 bytes"),
+		},
+		{
+			name:       "slurp",
+			headers:    map[string]string{"content-type": "application/x-ndjson", "x-goque-jq-filter": "map(.a)", "x-goque-slurp": "true"},
+			body:       "{\"a\":1}\n{\"a\":2}\n",
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `[1,2]`,
+		},
+		{
+			name:       "null input",
+			headers:    map[string]string{"x-goque-jq-filter": "[inputs.a]", "x-goque-null-input": "true"},
+			body:       "{\"a\":1}\n{\"a\":2}\n",
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON, wantBody: `[1,2]`,
+		},
+		{
+			name:       "invalid input mode",
+			headers:    map[string]string{"x-goque-jq-filter": ".", "x-goque-slurp": "sure"},
+			body:       body,
+			wantStatus: fiber.StatusBadRequest, wantType: MIMEApplicationProblemJSON,
+			wantBody: problem("400", "invalid_request", "Invalid request", "x-goque-slurp must be true or false"),
+		},
+		{
+			name:       "halt",
+			headers:    map[string]string{"x-goque-jq-filter": "halt"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantBody: ``,
+		},
+		{
+			name:       "halt_error",
+			configure:  func(c *Config) { c.HaltStatus = ParseHaltStatus("1:409") },
+			headers:    map[string]string{"x-goque-jq-filter": ".pineapple | halt_error(1)"},
+			body:       body,
+			wantStatus: fiber.StatusConflict, wantType: fiber.MIMEApplicationJSON, wantBody: `"nope."`,
+		},
+		{
+			name:       "debug xml",
+			configure:  func(c *Config) { c.DebugMode = true },
+			headers:    map[string]string{"accept": "application/xml", "x-goque-jq-filter": ".pineapple | debug", "x-goque-debug": "true"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: "application/xml; charset=utf-8",
+			wantBody: `<messages><type>debug</type><value>nope.</value></messages>`, contains: true,
+		},
+		{
+			name:       "halt_error with a non-error status",
+			headers:    map[string]string{"x-goque-jq-filter": `"x" | halt_error(204)`},
+			body:       body,
+			wantStatus: fiber.StatusUnprocessableEntity, wantType: fiber.MIMEApplicationJSON, wantBody: `"x"`,
+		},
+		{
+			name:       "halt_error mapped to no content",
+			configure:  func(c *Config) { c.HaltStatus = ParseHaltStatus("1:204") },
+			headers:    map[string]string{"x-goque-jq-filter": `"x" | halt_error(1)`},
+			body:       body,
+			wantStatus: fiber.StatusNoContent, wantBody: ``,
+		},
+		{
+			name:       "debug",
+			configure:  func(c *Config) { c.DebugMode = true },
+			headers:    map[string]string{"x-goque-jq-filter": ".pineapple | null, ascii_upcase", "x-goque-debug": "true"},
+			body:       body,
+			wantStatus: fiber.StatusOK, wantType: fiber.MIMEApplicationJSON,
+			wantHeaders: map[string]string{"x-goque-result-count": "2"},
+			wantBody:    `"messages":[]`, contains: true,
+		},
+	}
+
+	for _, tt := range tests {
+		e := _newEngine(t, tt.configure)
+		newReq := func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/jq", bytes.NewBufferString(tt.body))
+			req.Header.Set("content-type", "application/json")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			return req
+		}
+
+		for _, handler := range []struct {
+			name  string
+			serve func(*testing.T, *Engine, *http.Request, bool) *http.Response
+		}{
+			{"fiber", _serveFiber},
+			{"net/http", _serveHTTP},
+		} {
+			t.Run(tt.name+"/"+handler.name, func(t *testing.T) {
+				res := handler.serve(t, e, newReq(), tt.compress)
+				defer res.Body.Close()
+
+				raw, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+
+				assert.Equal(t, tt.wantStatus, res.StatusCode)
+				if tt.wantType != "" {
+					assert.Equal(t, tt.wantType, res.Header.Get("content-type"))
+				}
+				for k, v := range tt.wantHeaders {
+					assert.Equal(t, v, res.Header.Get(k), k)
+				}
+
+				if tt.contains {
+					assert.Contains(t, string(raw), tt.wantBody)
+				} else {
+					assert.Equal(t, tt.wantBody, string(raw))
+				}
+			})
+		}
+	}
+}
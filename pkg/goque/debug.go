@@ -0,0 +1,126 @@
+package goque
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// The max number of debug and stderr messages kept per request.
+const debugMessageLimit = 1000
+
+// debug and stderr as in the jq CLI, passing the input through. They
+// are replaced by a debugCollector in debug mode.
+var passthroughDebugOptions = []gojq.CompilerOption{
+	gojq.WithFunction("debug", 0, 0, func(v any, _ []any) any { return v }),
+	gojq.WithFunction("stderr", 0, 0, func(v any, _ []any) any { return v }),
+}
+
+// Collects the debug and stderr messages, evaluation time and result
+// count of a filter run for a x-goque-debug request. It is sent as
+// the plain value of value, so results render it as JSON and XML.
+type debugCollector struct {
+	Messages    []any // {"type": "debug" or "stderr", "value": ...}
+	Truncated   bool  // More than debugMessageLimit messages
+	EvalTime    float64
+	ResultCount int
+}
+
+// Returns d as a JSON value: messages, evalTimeMs, resultCount and
+// truncated if set.
+func (d *debugCollector) value() map[string]any {
+	v := map[string]any{
+		"messages":    d.Messages,
+		"evalTimeMs":  d.EvalTime,
+		"resultCount": d.ResultCount,
+	}
+	if d.Truncated {
+		v["truncated"] = true
+	}
+	return v
+}
+
+// Returns whether the request asked for debug mode and p allows it.
+func debugRequested(h Header, p *Config) bool {
+	if !p.DebugMode {
+		return false
+	}
+
+	enabled, _ := strconv.ParseBool(h.Get("x-goque-debug"))
+	return enabled
+}
+
+// Returns the compiler options replacing debug and stderr with
+// functions capturing into d.
+func (d *debugCollector) compilerOptions() []gojq.CompilerOption {
+	return []gojq.CompilerOption{
+		gojq.WithFunction("debug", 0, 0, d.collect("debug")),
+		gojq.WithFunction("stderr", 0, 0, d.collect("stderr")),
+	}
+}
+
+func (d *debugCollector) collect(kind string) func(any, []any) any {
+	return func(v any, _ []any) any {
+		if len(d.Messages) < debugMessageLimit {
+			d.Messages = append(d.Messages, map[string]any{"type": kind, "value": v})
+		} else {
+			d.Truncated = true
+		}
+		return v
+	}
+}
+
+// Runs code against body with ctx like GetFirstValueIter, recording
+// the evaluation time and the number of values emitted.
+func (d *debugCollector) run(ctx context.Context, code *gojq.Code, body any, maxResults int) (any, error) {
+	iter := &countingIter{iter: code.RunWithContext(ctx, body)}
+
+	start := time.Now()
+	out, err := GetFirstValueIter(iter, maxResults)
+	d.EvalTime = float64(time.Since(start).Microseconds()) / 1000
+	d.ResultCount = iter.count
+
+	return out, err
+}
+
+// Sends the outcome of a debug run. Results and halt values are
+// wrapped in {"result": ..., "debug": ...}, problems carry the debug
+// info as an extension member. The evaluation time and result count
+// are also sent as headers.
+func (e *Engine) sendDebug(x exchange, d *debugCollector, out any, err error) error {
+	x.Set("x-goque-eval-time", strconv.FormatFloat(d.EvalTime, 'f', -1, 64)+"ms")
+	x.Set("x-goque-result-count", strconv.Itoa(d.ResultCount))
+
+	if h, ok := err.(*HaltError); ok {
+		status := HaltStatus(&e.config, h.ExitCode)
+		x.Status(status)
+		if !haltBodyAllowed(status) {
+			return nil
+		}
+		return e.sendResult(x, map[string]any{"result": h.Value, "debug": d.value()})
+	}
+
+	if err != nil {
+		problem := *AsProblem(err)
+		problem.Debug = d.value()
+		return e.sendProblem(x, &problem)
+	}
+
+	return e.sendResult(x, map[string]any{"result": out, "debug": d.value()})
+}
+
+// Counts the non-error values emitted by an iter.
+type countingIter struct {
+	iter  gojq.Iter
+	count int
+}
+
+func (i *countingIter) Next() (any, bool) {
+	v, ok := i.iter.Next()
+	if _, isErr := v.(error); ok && !isErr {
+		i.count++
+	}
+	return v, ok
+}
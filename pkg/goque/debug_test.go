@@ -0,0 +1,74 @@
+package goque
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func _handleDebugRequest(t *testing.T, e *Engine, filter string) (*fiber.Ctx, map[string]any) {
+	c := _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("x-goque-debug", "true")
+	if filter != "" {
+		c.Context().Request.Header.Add("x-goque-jq-filter", filter)
+	}
+
+	assert.NoError(t, e.Handler()(c))
+
+	var res map[string]any
+	json.Unmarshal(c.Response().Body(), &res)
+	return c, res
+}
+
+func TestHandlerDebugDisabled(t *testing.T) {
+	e := _newEngine(t, nil)
+	c, _ := _handleDebugRequest(t, e, ".peanuts | debug | stderr")
+
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+	assert.Equal(t, "true", string(c.Response().Body()))
+	assert.Empty(t, c.GetRespHeader("x-goque-result-count"))
+}
+
+func TestHandlerDebug(t *testing.T) {
+	e := _newEngine(t, func(c *Config) { c.DebugMode = true })
+	c, res := _handleDebugRequest(t, e, `.pineapple | debug | stderr | null, ascii_upcase`)
+
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+	assert.Equal(t, "NOPE.", res["result"])
+	assert.Equal(t, "2", c.GetRespHeader("x-goque-result-count"))
+	assert.NotEmpty(t, c.GetRespHeader("x-goque-eval-time"))
+
+	debug := res["debug"].(map[string]any)
+	assert.Equal(t, float64(2), debug["resultCount"])
+	assert.Contains(t, debug, "evalTimeMs")
+	assert.Equal(t, []any{
+		map[string]any{"type": "debug", "value": "nope."},
+		map[string]any{"type": "stderr", "value": "nope."},
+	}, debug["messages"])
+}
+
+func TestHandlerDebugCompiled(t *testing.T) {
+	e := _newEngine(t, func(c *Config) {
+		c.DebugMode = true
+		c.Filter = ".peanuts | debug"
+	})
+	c, res := _handleDebugRequest(t, e, "")
+
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+	assert.Equal(t, true, res["result"])
+	assert.Equal(t, []any{map[string]any{"type": "debug", "value": true}}, res["debug"].(map[string]any)["messages"])
+}
+
+func TestHandlerDebugProblem(t *testing.T) {
+	e := _newEngine(t, func(c *Config) { c.DebugMode = true })
+	c, res := _handleDebugRequest(t, e, `.pineapple | debug | error`)
+
+	assert.Equal(t, fiber.StatusUnprocessableEntity, c.Response().StatusCode())
+	assert.Equal(t, string(ErrFilterRuntime), res["code"])
+	assert.Equal(t, []any{map[string]any{"type": "debug", "value": "nope."}}, res["debug"].(map[string]any)["messages"])
+}
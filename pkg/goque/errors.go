@@ -0,0 +1,201 @@
+package goque
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
+)
+
+// The content type of error responses, see RFC 7807.
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// A stable, machine readable error code. Each code is sent with one
+// HTTP status, see errorCodes.
+type ErrorCode string
+
+const (
+	ErrInvalidRequest       ErrorCode = "invalid_request"
+	ErrInvalidBody          ErrorCode = "invalid_body"
+	ErrMissingFilter        ErrorCode = "missing_filter"
+	ErrFilterParse          ErrorCode = "filter_parse_error"
+	ErrFuncNotAllowed       ErrorCode = "function_not_allowed"
+	ErrFilterForbidden      ErrorCode = "filter_forbidden"
+	ErrNotFound             ErrorCode = "not_found"
+	ErrMethodNotAllowed     ErrorCode = "method_not_allowed"
+	ErrBodyTooLarge         ErrorCode = "body_too_large"
+	ErrUnsupportedEncoding  ErrorCode = "unsupported_encoding"
+	ErrUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	ErrFilterCompile        ErrorCode = "filter_compile_error"
+	ErrBodyLimit            ErrorCode = "body_limit_exceeded"
+	ErrFilterRuntime        ErrorCode = "filter_runtime_error"
+	ErrResultLimit          ErrorCode = "result_limit_exceeded"
+	ErrTimeLimit            ErrorCode = "time_limit_exceeded"
+	ErrOutputLimit          ErrorCode = "output_limit_exceeded"
+	ErrXMLEncoding          ErrorCode = "xml_encoding_error"
+	ErrInternal             ErrorCode = "internal_error"
+)
+
+type errorCode struct {
+	status int
+	title  string
+}
+
+// The status and title of each error code. Malformed requests are
+// 400, well formed requests that cannot be processed are 422 and
+// failures of goque itself are 500.
+var errorCodes = map[ErrorCode]errorCode{
+	ErrInvalidRequest:       {fiber.StatusBadRequest, "Invalid request"},
+	ErrInvalidBody:          {fiber.StatusBadRequest, "Invalid request body"},
+	ErrMissingFilter:        {fiber.StatusBadRequest, "Missing filter"},
+	ErrFilterParse:          {fiber.StatusBadRequest, "Filter parse error"},
+	ErrFuncNotAllowed:       {fiber.StatusBadRequest, "Function not allowed"},
+	ErrFilterForbidden:      {fiber.StatusForbidden, "Filter forbidden"},
+	ErrNotFound:             {fiber.StatusNotFound, "Not found"},
+	ErrMethodNotAllowed:     {fiber.StatusMethodNotAllowed, "Method not allowed"},
+	ErrBodyTooLarge:         {fiber.StatusRequestEntityTooLarge, "Request body too large"},
+	ErrUnsupportedEncoding:  {fiber.StatusUnsupportedMediaType, "Unsupported content encoding"},
+	ErrUnsupportedMediaType: {fiber.StatusUnsupportedMediaType, "Unsupported media type"},
+	ErrFilterCompile:        {fiber.StatusUnprocessableEntity, "Filter compile error"},
+	ErrBodyLimit:            {fiber.StatusUnprocessableEntity, "Body limit exceeded"},
+	ErrFilterRuntime:        {fiber.StatusUnprocessableEntity, "Filter runtime error"},
+	ErrResultLimit:          {fiber.StatusUnprocessableEntity, "Result limit exceeded"},
+	ErrTimeLimit:            {fiber.StatusUnprocessableEntity, "Time limit exceeded"},
+	ErrOutputLimit:          {fiber.StatusInsufficientStorage, "Output limit exceeded"},
+	ErrXMLEncoding:          {fiber.StatusUnprocessableEntity, "XML encoding error"},
+	ErrInternal:             {fiber.StatusInternalServerError, "Internal server error"},
+}
+
+// Returns every error code, sorted.
+func ErrorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(errorCodes))
+	for code := range errorCodes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	return codes
+}
+
+// Returns the HTTP status of problems with the code.
+func (code ErrorCode) Status() int {
+	ec, ok := errorCodes[code]
+	if !ok {
+		ec = errorCodes[ErrInternal]
+	}
+
+	return ec.status
+}
+
+// An RFC 7807 problem detail, sent as application/problem+json. Code,
+// the filter location and the error value are extension members.
+type Problem struct {
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	Status int       `json:"status"`
+	Detail string    `json:"detail,omitempty"`
+	Code   ErrorCode `json:"code"`
+	Offset *int      `json:"offset,omitempty"` // Byte offset in the filter
+	Line   int       `json:"line,omitempty"`   // 1-based line in the filter
+	Column int       `json:"column,omitempty"` // 1-based rune column in the filter
+	Value  any       `json:"value,omitempty"`  // The value passed to error(...)
+	Debug  any       `json:"debug,omitempty"`  // Debug info for x-goque-debug requests
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// Returns a problem for code with the code's status and title.
+func NewProblem(code ErrorCode, detail string) *Problem {
+	ec, ok := errorCodes[code]
+	if !ok {
+		ec = errorCodes[ErrInternal]
+	}
+
+	return &Problem{
+		Type:   "urn:goque:problem:" + string(code),
+		Title:  ec.title,
+		Status: ec.status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Returns a filter_runtime_error problem for an error raised while
+// running a filter, with the value passed to error(...) if any.
+func RuntimeProblem(err error) *Problem {
+	// gojq stops input with a break error once the inputs run out
+	if err.Error() == "break" {
+		return NewProblem(ErrFilterRuntime, "No more inputs")
+	}
+
+	p := NewProblem(ErrFilterRuntime, err.Error())
+	if e, ok := err.(gojq.ValueError); ok {
+		p.Value = e.Value()
+	}
+	return p
+}
+
+// Converts err into a problem. Filter errors keep their location,
+// *fiber.Error keeps its status, anything else is an internal error.
+func AsProblem(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var filterErr *FilterError
+	if errors.As(err, &filterErr) {
+		p = NewProblem(filterErr.Code, filterErr.Message)
+		if filterErr.Offset >= 0 {
+			offset := filterErr.Offset
+			p.Offset, p.Line, p.Column = &offset, filterErr.Line, filterErr.Column
+		}
+		return p
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		switch fiberErr.Code {
+		case fiber.StatusNotFound:
+			p = NewProblem(ErrNotFound, fiberErr.Message)
+		case fiber.StatusMethodNotAllowed:
+			p = NewProblem(ErrMethodNotAllowed, fiberErr.Message)
+		case fiber.StatusRequestEntityTooLarge:
+			p = NewProblem(ErrBodyTooLarge, fiberErr.Message)
+		default:
+			if fiberErr.Code >= fiber.StatusInternalServerError {
+				p = NewProblem(ErrInternal, fiberErr.Message)
+			} else {
+				p = NewProblem(ErrInvalidRequest, fiberErr.Message)
+			}
+			p.Status = fiberErr.Code
+		}
+		return p
+	}
+
+	return NewProblem(ErrInternal, err.Error())
+}
+
+// Sends err as an application/problem+json response, see AsProblem.
+// It can be used as the fiber.Config ErrorHandler, so errors raised
+// outside of the handlers (e.g. a body over the BodyLimit or an
+// unknown route) are sent like handler errors.
+func (e *Engine) HandleError(c *fiber.Ctx, err error) error {
+	return e.sendProblem(fiberExchange{c}, err)
+}
+
+func (e *Engine) sendProblem(x exchange, err error) error {
+	p := AsProblem(err)
+
+	raw, err := JSONAPI(e.config.EscapeHTML, false).Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	x.Status(p.Status)
+	x.Set(fiber.HeaderContentType, MIMEApplicationProblemJSON)
+	return x.Send(raw)
+}
@@ -0,0 +1,100 @@
+package goque
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProblem(t *testing.T) {
+	for _, code := range ErrorCodes() {
+		p := NewProblem(code, "nope.")
+		assert.Equal(t, code.Status(), p.Status, code)
+		assert.Equal(t, "urn:goque:problem:"+string(code), p.Type)
+		assert.EqualError(t, p, "nope.")
+	}
+}
+
+func TestAsProblem(t *testing.T) {
+	assert.Equal(t, ErrNotFound, AsProblem(fiber.ErrNotFound).Code)
+	assert.Equal(t, ErrBodyTooLarge, AsProblem(fiber.ErrRequestEntityTooLarge).Code)
+
+	p := AsProblem(fiber.ErrConflict)
+	assert.Equal(t, ErrInvalidRequest, p.Code)
+	assert.Equal(t, fiber.StatusConflict, p.Status)
+
+	p = AsProblem(errors.New("nope."))
+	assert.Equal(t, ErrInternal, p.Code)
+	assert.Equal(t, fiber.StatusInternalServerError, p.Status)
+}
+
+func TestHandlerProblems(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		filter      string
+		resCode     int
+		resBody     string
+	}{
+		{
+			"bad body",
+			"application/json",
+			`{"peanuts":`,
+			".",
+			fiber.StatusBadRequest,
+			`{"type":"urn:goque:problem:invalid_body","title":"Invalid request body","status":400,"detail":"Read: unexpected value type: 0, error found in #10 byte of ...|\"peanuts\":|..., bigger context ...|{\"peanuts\":|...","code":"invalid_body"}`,
+		},
+		{
+			"unsupported content type",
+			"text/plain",
+			`peanuts`,
+			".",
+			fiber.StatusUnsupportedMediaType,
+			`{"type":"urn:goque:problem:unsupported_media_type","title":"Unsupported media type","status":415,"detail":"Unsupported Content-Type: text/plain","code":"unsupported_media_type"}`,
+		},
+		{
+			"compile error",
+			"application/json",
+			`{"peanuts":true}`,
+			".peanuts |\n pineapple",
+			fiber.StatusUnprocessableEntity,
+			`{"type":"urn:goque:problem:filter_compile_error","title":"Filter compile error","status":422,"detail":"function not defined: pineapple/0","code":"filter_compile_error","offset":12,"line":2,"column":2}`,
+		},
+		{
+			"runtime error value",
+			"application/json",
+			`{"peanuts":true}`,
+			`error({reason: "nope.", peanuts: .peanuts})`,
+			fiber.StatusUnprocessableEntity,
+			`{"type":"urn:goque:problem:filter_runtime_error","title":"Filter runtime error","status":422,"detail":"error: {\"peanuts\":true,\"reason\":\"nope.\"}","code":"filter_runtime_error","value":{"reason":"nope.","peanuts":true}}`,
+		},
+		{
+			"runtime error",
+			"application/json",
+			`{"peanuts":true}`,
+			`.peanuts[]`,
+			fiber.StatusUnprocessableEntity,
+			`{"type":"urn:goque:problem:filter_runtime_error","title":"Filter runtime error","status":422,"detail":"cannot iterate over: boolean (true)","code":"filter_runtime_error"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := _newEngine(t, nil)
+			c := _GetNewFiberContext()
+
+			c.Context().Request.SetBody([]byte(tt.body))
+			c.Context().Request.Header.Add("content-type", tt.contentType)
+			c.Context().Request.Header.Add("x-goque-jq-filter", tt.filter)
+
+			assert.NoError(t, e.Handler()(c))
+			assert.Equal(t, tt.resCode, c.Response().StatusCode())
+			assert.Equal(t, MIMEApplicationProblemJSON, string(c.Response().Header.ContentType()))
+
+			assert.JSONEq(t, tt.resBody, string(c.Response().Body()))
+		})
+	}
+}
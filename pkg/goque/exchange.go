@@ -0,0 +1,83 @@
+package goque
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The request headers read by the handlers, e.g. http.Header. Get
+// returns "" for headers that are not set.
+type Header interface {
+	Get(key string) string
+}
+
+// A jq request and its response. The fiber and net/http handlers
+// implement it over their own request types, so both run handlePost
+// with the same headers, errors and formats.
+type exchange interface {
+	Header // Of the request
+
+	Body() []byte             // The raw request body
+	Context() context.Context // Done once the request is gone
+	IP() string               // The client IP, for audit logs
+	Path() string             // The request path, for audit logs
+	Status(status int)        // Sets the response status
+	Set(key, value string)    // Sets a response header
+	Send(body []byte) error   // Sends the response body
+}
+
+type fiberExchange struct {
+	c *fiber.Ctx
+}
+
+func (x fiberExchange) Get(key string) string    { return x.c.Get(key) }
+func (x fiberExchange) Body() []byte             { return x.c.Request().Body() }
+func (x fiberExchange) Context() context.Context { return x.c.UserContext() }
+func (x fiberExchange) IP() string               { return x.c.IP() }
+func (x fiberExchange) Path() string             { return x.c.Path() }
+func (x fiberExchange) Status(status int)        { x.c.Status(status) }
+func (x fiberExchange) Set(key, value string)    { x.c.Set(key, value) }
+func (x fiberExchange) Send(body []byte) error   { return x.c.Send(body) }
+
+// Buffers the response status until the body is sent, as net/http
+// writes the status with the first byte of the body. The status is
+// written by finish if nothing was sent, e.g. for halt.
+type httpExchange struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	body   []byte
+	status int
+	sent   bool
+}
+
+func (x *httpExchange) Get(key string) string    { return x.r.Header.Get(key) }
+func (x *httpExchange) Body() []byte             { return x.body }
+func (x *httpExchange) Context() context.Context { return x.r.Context() }
+func (x *httpExchange) Path() string             { return x.r.URL.Path }
+func (x *httpExchange) Status(status int)        { x.status = status }
+func (x *httpExchange) Set(key, value string)    { x.w.Header().Set(key, value) }
+
+func (x *httpExchange) IP() string {
+	host, _, err := net.SplitHostPort(x.r.RemoteAddr)
+	if err != nil {
+		return x.r.RemoteAddr
+	}
+	return host
+}
+
+func (x *httpExchange) Send(body []byte) error {
+	x.w.WriteHeader(x.status)
+	x.sent = true
+
+	_, err := x.w.Write(body)
+	return err
+}
+
+func (x *httpExchange) finish() {
+	if !x.sent {
+		x.w.WriteHeader(x.status)
+	}
+}
@@ -0,0 +1,212 @@
+package goque
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// The widest indent accepted, as in jq --indent.
+const MaxIndent = 7
+
+// How JSON results are rendered.
+type OutputFormat struct {
+	SortKeys bool   // Sort object keys, as jq -S
+	Indent   string // Indent per level, empty for compact output
+}
+
+// Parses an indent setting: a number of spaces from 0 (compact) to 7,
+// or "tab". Returns the indent string and whether s was valid.
+func ParseIndent(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "tab") {
+		return "\t", true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > MaxIndent {
+		return "", false
+	}
+
+	return strings.Repeat(" ", n), true
+}
+
+// Returns the output format of p, overridden by the x-goque-sort-keys
+// and x-goque-indent headers. Invalid headers return a 400 problem.
+func GetOutputFormat(h Header, p *Config) (OutputFormat, error) {
+	f := OutputFormat{SortKeys: p.SortKeys, Indent: p.Indent}
+
+	if v := h.Get("x-goque-sort-keys"); v != "" {
+		sortKeys, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, NewProblem(ErrInvalidRequest, "x-goque-sort-keys must be true or false")
+		}
+		f.SortKeys = sortKeys
+	}
+
+	if v := h.Get("x-goque-indent"); v != "" {
+		indent, ok := ParseIndent(v)
+		if !ok {
+			return f, NewProblem(ErrInvalidRequest, "x-goque-indent must be 0-"+strconv.Itoa(MaxIndent)+" or tab")
+		}
+		f.Indent = indent
+	}
+
+	return f, nil
+}
+
+// Picks the response media type from an Accept header, as
+// fiber.Ctx.Accepts does for MIME types: media ranges are tried in
+// header order, q-values are ignored, and the first offer matching a
+// range wins. An empty header accepts the first offer. Returns "" if
+// no offer is acceptable.
+func NegotiateMediaType(accept string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if accept == "" {
+		return offers[0]
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		spec, _, _ := strings.Cut(part, ";")
+		spec = strings.TrimSpace(spec)
+
+		for _, offer := range offers {
+			if spec == "*/*" || spec == offer {
+				return offer
+			}
+
+			// type/* ranges
+			mediaType, _, _ := strings.Cut(offer, "/")
+			if specType, subtype, _ := strings.Cut(spec, "/"); specType == mediaType && subtype == "*" {
+				return offer
+			}
+		}
+	}
+
+	return ""
+}
+
+type jsonAPIKey struct {
+	escapeHTML bool
+	sortKeys   bool
+}
+
+var (
+	jsonAPIsMu sync.Mutex
+	jsonAPIs   = make(map[jsonAPIKey]jsoniter.API)
+)
+
+// Returns a jsoniter API by escapeHTML and sortKeys. APIs are cached
+// since freezing a config is expensive.
+func JSONAPI(escapeHTML bool, sortKeys bool) jsoniter.API {
+	jsonAPIsMu.Lock()
+	defer jsonAPIsMu.Unlock()
+
+	key := jsonAPIKey{escapeHTML, sortKeys}
+	api, ok := jsonAPIs[key]
+	if !ok {
+		api = jsoniter.Config{EscapeHTML: escapeHTML, SortMapKeys: sortKeys}.Froze()
+		jsonAPIs[key] = api
+	}
+
+	return api
+}
+
+// The buffered JSON written past this size is flushed to the writer.
+const jsonFlushSize = 4096
+
+// Writes v to w as JSON in format f, escaping HTML per
+// Config.EscapeHTML. Arrays and objects are walked here and flushed as
+// they are written, so a limited w stops the encoding once it is full
+// instead of after the whole output was built. The output is the same
+// as json.Indent of the compact JSON.
+func (e *Engine) writeJSON(w io.Writer, v any, f OutputFormat) error {
+	api := JSONAPI(e.config.EscapeHTML, false)
+	stream := api.BorrowStream(w)
+	defer api.ReturnStream(stream)
+
+	jw := jsonWriter{Stream: stream, format: f}
+	if jw.value(v, 0) {
+		stream.Flush()
+	}
+
+	return stream.Error
+}
+
+type jsonWriter struct {
+	*jsoniter.Stream
+	format OutputFormat
+}
+
+// Writes v at the nesting depth, returning false once writing failed.
+func (w jsonWriter) value(v any, depth int) bool {
+	switch v := v.(type) {
+	case []any:
+		if len(v) == 0 {
+			w.WriteRaw("[]")
+			break
+		}
+
+		w.WriteRaw("[")
+		for i, item := range v {
+			if i > 0 {
+				w.WriteRaw(",")
+			}
+			w.newline(depth + 1)
+			if !w.value(item, depth+1) {
+				return false
+			}
+		}
+		w.newline(depth)
+		w.WriteRaw("]")
+	case map[string]any:
+		if len(v) == 0 {
+			w.WriteRaw("{}")
+			break
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		if w.format.SortKeys {
+			sort.Strings(keys)
+		}
+
+		w.WriteRaw("{")
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteRaw(",")
+			}
+			w.newline(depth + 1)
+			w.WriteVal(k)
+			if w.WriteRaw(":"); w.format.Indent != "" {
+				w.WriteRaw(" ")
+			}
+			if !w.value(v[k], depth+1) {
+				return false
+			}
+		}
+		w.newline(depth)
+		w.WriteRaw("}")
+	default:
+		w.WriteVal(v)
+	}
+
+	if w.Buffered() >= jsonFlushSize {
+		w.Flush()
+	}
+	return w.Error == nil
+}
+
+func (w jsonWriter) newline(depth int) {
+	if w.format.Indent != "" {
+		w.WriteRaw("\n" + strings.Repeat(w.format.Indent, depth))
+	}
+}
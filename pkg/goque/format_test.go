@@ -0,0 +1,85 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeJSON writes what json.Indent makes of the compact JSON
+func TestWriteJSON(t *testing.T) {
+	values := []any{
+		nil, true, 1, 1.5, "<a&b>", big.NewInt(1).Lsh(big.NewInt(1), 80),
+		[]any{}, map[string]any{},
+		[]any{1, []any{}, []any{[]any{"x"}}, map[string]any{}},
+		map[string]any{"b": []any{1, 2}, "a": map[string]any{"<c>": nil, "d": map[string]any{}}},
+	}
+
+	for _, escapeHTML := range []bool{false, true} {
+		e := _newEngine(t, func(c *Config) { c.EscapeHTML = escapeHTML })
+
+		for _, v := range values {
+			compact, err := JSONAPI(escapeHTML, true).Marshal(v)
+			assert.NoError(t, err)
+
+			for _, indent := range []string{"", "  ", "\t"} {
+				var want bytes.Buffer
+				if indent == "" {
+					want.Write(compact)
+				} else {
+					assert.NoError(t, json.Indent(&want, compact, "", indent))
+				}
+
+				var got bytes.Buffer
+				assert.NoError(t, e.writeJSON(&got, v, OutputFormat{SortKeys: true, Indent: indent}))
+				assert.Equal(t, want.String(), got.String(), "%v %q", v, indent)
+			}
+		}
+	}
+}
+
+func TestParseIndent(t *testing.T) {
+	tests := []struct {
+		s      string
+		indent string
+		ok     bool
+	}{
+		{"0", "", true},
+		{"2", "  ", true},
+		{" 7 ", "       ", true},
+		{"TAB", "\t", true},
+		{"8", "", false},
+		{"-1", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		indent, ok := ParseIndent(tt.s)
+		assert.Equalf(t, tt.indent, indent, "%q", tt.s)
+		assert.Equalf(t, tt.ok, ok, "%q", tt.s)
+	}
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{"application/json", "application/xml", "text/xml"}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/html, application/xml;q=0.9", "application/xml"},
+		{"text/*", "text/xml"},
+		{" text/xml ; q=1, application/json", "text/xml"},
+		{"text/html", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equalf(t, tt.want, NegotiateMediaType(tt.accept, offers...), "%q", tt.accept)
+	}
+}
@@ -0,0 +1,589 @@
+package goque
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/itchyny/gojq"
+	"github.com/rs/zerolog/log"
+)
+
+// Returned when a filter calls a function rejected by the function
+// allowlist or denylist.
+type FuncPolicyError struct {
+	Name   string // The function name and arity, e.g. debug/0, or the format, e.g. @sh
+	Offset int    // Byte offset of the call in the filter, -1 if unknown
+	Line   int    // 1-based line of the call, 0 if unknown
+	Column int    // 1-based column of the call in runes, 0 if unknown
+}
+
+func (e *FuncPolicyError) Error() string {
+	msg := "function not allowed: " + e.Name
+	if e.Offset >= 0 {
+		msg += " at line " + strconv.Itoa(e.Line) + ", column " + strconv.Itoa(e.Column)
+	}
+	return msg
+}
+
+// Parses a comma separated list of function names into a set. Entries
+// are function names (all arities), name/arity, or formats like @sh.
+// Invalid entries are skipped with a warning. Returns nil for an
+// empty list.
+func ParseFuncList(s string) map[string]bool {
+	var funcs map[string]bool
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !IsFuncListEntry(entry) {
+			log.Warn().Str("entry", entry).Msg("Invalid function list entry, expected name, name/arity or @format")
+			continue
+		}
+		if funcs == nil {
+			funcs = make(map[string]bool)
+		}
+		funcs[entry] = true
+	}
+
+	return funcs
+}
+
+// Parses a comma separated list of function lists by route path, e.g.
+// "/public:input,inputs,/internal:", see ParseFuncList. An entry
+// starting with '/' is a path, a colon and the first function of its
+// list, the entries up to the next path are the other functions. A
+// path without functions gets an empty list, which lifts the global
+// list for the route. Invalid entries are skipped with a warning.
+// Returns nil for an empty list.
+func ParseRouteFuncLists(s string) map[string]map[string]bool {
+	var lists map[string]map[string]bool
+
+	for path, entries := range parseRouteLists(s, IsFuncListEntry, "name, name/arity or @format") {
+		if lists == nil {
+			lists = make(map[string]map[string]bool)
+		}
+		lists[path] = make(map[string]bool)
+		for _, entry := range entries {
+			lists[path][entry] = true
+		}
+	}
+
+	return lists
+}
+
+// Reports whether entry is valid in a list parsed by
+// ParseRouteFuncLists.
+func IsRouteFuncListEntry(entry string) bool {
+	return isRouteListEntry(entry, IsFuncListEntry)
+}
+
+func IsFuncListEntry(entry string) bool {
+	if strings.HasPrefix(entry, "@") {
+		return isIdentifier(entry[1:])
+	}
+
+	name, arity, hasArity := strings.Cut(entry, "/")
+	if hasArity {
+		if n, err := strconv.Atoi(arity); err != nil || n < 0 {
+			return false
+		}
+	}
+
+	for _, part := range strings.Split(name, "::") {
+		if !isIdentifier(part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Walks the parsed filter and checks every builtin function call and
+// format against p.FuncAllow and p.FuncDeny. Functions defined in the
+// filter itself are allowed where jq resolves calls to them: in their
+// own body, in later definitions and in the query they are defined
+// for. Builtins that gojq defines in jq are denied with the builtins
+// they call, see builtinCalls, $ENV is checked as env and
+// format("sh") as @sh. src is the filter text, used to locate the
+// offending call.
+func CheckFuncPolicy(query *gojq.Query, src string, p *Config) error {
+	if len(p.FuncAllow) == 0 && len(p.FuncDeny) == 0 {
+		return nil
+	}
+
+	w := &funcPolicyWalker{p: p}
+	w.query(query)
+
+	if w.denied == "" {
+		return nil
+	}
+
+	err := &FuncPolicyError{Name: w.denied, Offset: -1}
+	if offset := findCallOffset(src, w.deniedToken, w.deniedArity); offset >= 0 {
+		err.Offset = offset
+		err.Line, err.Column = lineColumn(src, offset)
+	}
+
+	return err
+}
+
+// Returns whether a builtin call of name with arity is permitted.
+func funcAllowed(p *Config, name string, arity int) bool {
+	key := name + "/" + strconv.Itoa(arity)
+
+	if p.FuncDeny[name] || p.FuncDeny[key] {
+		return false
+	}
+
+	if len(p.FuncAllow) > 0 && !p.FuncAllow[name] && !p.FuncAllow[key] {
+		return false
+	}
+
+	return true
+}
+
+// The builtins called by each builtin gojq defines in jq (see gojq's
+// builtin.jq), by name/arity. Internal functions starting with _ are
+// left out. A denied function denies every builtin reaching it, e.g.
+// denying input denies inputs.
+var builtinCalls = map[string][]string{
+	"in/1":              {"has/1"},
+	"with_entries/1":    {"to_entries/0", "map/1", "from_entries/0"},
+	"select/1":          {"empty/0"},
+	"recurse/0":         {"recurse/1"},
+	"recurse/2":         {"select/1"},
+	"while/2":           {"empty/0"},
+	"min_by/1":          {"map/1"},
+	"max_by/1":          {"map/1"},
+	"sort_by/1":         {"map/1"},
+	"group_by/1":        {"map/1"},
+	"unique_by/1":       {"map/1"},
+	"arrays/0":          {"select/1", "type/0"},
+	"objects/0":         {"select/1", "type/0"},
+	"iterables/0":       {"select/1", "type/0"},
+	"booleans/0":        {"select/1", "type/0"},
+	"numbers/0":         {"select/1", "type/0"},
+	"finites/0":         {"select/1", "isfinite/0"},
+	"normals/0":         {"select/1", "isnormal/0"},
+	"strings/0":         {"select/1", "type/0"},
+	"nulls/0":           {"select/1"},
+	"values/0":          {"select/1"},
+	"scalars/0":         {"select/1", "type/0"},
+	"leaf_paths/0":      {"paths/1", "scalars/0"},
+	"inside/1":          {"contains/1"},
+	"combinations/0":    {"length/0"},
+	"combinations/1":    {"limit/2", "repeat/1", "combinations/0"},
+	"walk/1":            {"type/0", "map/1", "map_values/1", "last/1"},
+	"all/0":             {"all/1"},
+	"all/1":             {"all/2"},
+	"all/2":             {"isempty/1", "select/1", "not/0"},
+	"any/0":             {"any/1"},
+	"any/1":             {"any/2"},
+	"any/2":             {"isempty/1", "select/1", "not/0"},
+	"limit/2":           {"empty/0"},
+	"nth/2":             {"error/1", "empty/0"},
+	"truncate_stream/1": {"length/0", "empty/0"},
+	"fromstream/1":      {"setpath/2", "length/0", "empty/0"},
+	"tostream/0":        {"path/1", "getpath/1"},
+	"del/1":             {"delpaths/1", "path/1"},
+	"paths/0":           {"path/1", "select/1"},
+	"paths/1":           {"paths/0", "select/1", "getpath/1"},
+	"fromdateiso8601/0": {"strptime/1", "mktime/0"},
+	"todateiso8601/0":   {"strftime/1"},
+	"fromdate/0":        {"fromdateiso8601/0"},
+	"todate/0":          {"todateiso8601/0"},
+	"match/1":           {"match/2"},
+	"test/1":            {"test/2"},
+	"capture/1":         {"capture/2"},
+	"capture/2":         {"match/2"},
+	"scan/1":            {"scan/2"},
+	"scan/2":            {"match/2"},
+	"splits/1":          {"splits/2"},
+	"splits/2":          {"split/2"},
+	"sub/2":             {"sub/3"},
+	"sub/3":             {"match/2"},
+	"gsub/2":            {"sub/3"},
+	"gsub/3":            {"sub/3"},
+	"inputs/0":          {"repeat/1", "input/0", "error/0", "empty/0"},
+	"INDEX/1":           {"INDEX/2"},
+	"INDEX/2":           {"tostring/0"},
+	"IN/1":              {"any/2"},
+	"IN/2":              {"any/2"},
+}
+
+// Returns whether p.FuncDeny denies a builtin that key calls, directly
+// or through other builtins, see builtinCalls.
+func callsDenied(p *Config, key string, seen map[string]bool) bool {
+	for _, callee := range builtinCalls[key] {
+		if seen[callee] {
+			continue
+		}
+		seen[callee] = true
+
+		name, _, _ := strings.Cut(callee, "/")
+		if p.FuncDeny[name] || p.FuncDeny[callee] || callsDenied(p, callee, seen) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type funcPolicyWalker struct {
+	p           *Config
+	scopes      []map[string]bool // Functions and params defined by the filter
+	denied      string            // The first denied function, name/arity
+	deniedToken string            // The token to locate in the source
+	deniedArity int               // The arity of the denied call
+
+	// Set when locating a call, see findCallOffset
+	probe      string // The renamed token
+	probeToken string // The token before renaming
+	probeArity int    // The arity of the call
+	found      bool   // Whether the filter calls the renamed token
+}
+
+func (w *funcPolicyWalker) defined(key string) bool {
+	for i := len(w.scopes) - 1; i >= 0; i-- {
+		if w.scopes[i][key] {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *funcPolicyWalker) call(name string, args []*gojq.Query) {
+	for _, arg := range args {
+		w.query(arg)
+	}
+
+	if w.probe != "" {
+		// A variable is the probe wherever it is read, a function
+		// where it is not defined by the filter
+		w.found = w.found || name == w.probe && len(args) == w.probeArity &&
+			(strings.HasPrefix(name, "$") || !w.defined(w.probeToken+"/"+strconv.Itoa(len(args))))
+		return
+	}
+
+	// Variables are not function calls, but $ENV reads what env does.
+	// It is checked even if the filter binds its own $ENV.
+	token := name
+	if name == "$ENV" {
+		name = "env"
+	} else if strings.HasPrefix(name, "$") {
+		return
+	}
+
+	key := name + "/" + strconv.Itoa(len(args))
+	if w.denied != "" || w.defined(key) {
+		return
+	}
+
+	if !funcAllowed(w.p, name, len(args)) || callsDenied(w.p, key, map[string]bool{}) {
+		w.denied, w.deniedToken, w.deniedArity = key, token, len(args)
+		return
+	}
+
+	// format("sh") is @sh, a format picked at runtime could be any
+	if key == "format/1" {
+		if f := args[0].Term; f != nil && f.Type == gojq.TermTypeString && f.Str != nil && len(f.Str.Queries) == 0 {
+			if format := "@" + f.Str.Str; !formatAllowed(w.p, format) {
+				w.denied, w.deniedToken, w.deniedArity = format, token, 1
+			}
+		} else if formatsDenied(w.p) {
+			w.denied, w.deniedToken, w.deniedArity = key, token, 1
+		}
+	}
+}
+
+func formatAllowed(p *Config, format string) bool {
+	return !p.FuncDeny[format] && (len(p.FuncAllow) == 0 || p.FuncAllow[format])
+}
+
+// Returns whether p.FuncDeny denies any format.
+func formatsDenied(p *Config) bool {
+	for entry := range p.FuncDeny {
+		if strings.HasPrefix(entry, "@") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *funcPolicyWalker) format(format string) {
+	if w.denied != "" || format == "" {
+		return
+	}
+
+	if w.probe != "" {
+		w.found = w.found || format == w.probe
+		return
+	}
+
+	if !formatAllowed(w.p, format) {
+		w.denied, w.deniedToken = format, format
+	}
+}
+
+func (w *funcPolicyWalker) query(q *gojq.Query) {
+	if q == nil || w.denied != "" {
+		return
+	}
+
+	if len(q.FuncDefs) > 0 {
+		// Each definition sees itself and the ones before it, the
+		// query sees all of them
+		scope := make(map[string]bool)
+		w.scopes = append(w.scopes, scope)
+		defer func() { w.scopes = w.scopes[:len(w.scopes)-1] }()
+
+		for _, fd := range q.FuncDefs {
+			scope[fd.Name+"/"+strconv.Itoa(len(fd.Args))] = true
+
+			params := make(map[string]bool)
+			for _, arg := range fd.Args {
+				params[strings.TrimPrefix(arg, "$")+"/0"] = true
+			}
+			w.scopes = append(w.scopes, params)
+			w.query(fd.Body)
+			w.scopes = w.scopes[:len(w.scopes)-1]
+		}
+	}
+
+	if q.Func != "" {
+		w.call(q.Func, nil)
+	}
+
+	w.term(q.Term)
+	w.query(q.Left)
+	w.query(q.Right)
+}
+
+func (w *funcPolicyWalker) term(t *gojq.Term) {
+	if t == nil || w.denied != "" {
+		return
+	}
+
+	if t.Func != nil {
+		w.call(t.Func.Name, t.Func.Args)
+	}
+
+	w.format(t.Format)
+	w.index(t.Index)
+	w.str(t.Str)
+	w.query(t.Query)
+
+	if t.Object != nil {
+		for _, kv := range t.Object.KeyVals {
+			w.str(kv.KeyString)
+			w.query(kv.KeyQuery)
+			if kv.Val != nil {
+				for _, q := range kv.Val.Queries {
+					w.query(q)
+				}
+			}
+		}
+	}
+
+	if t.Array != nil {
+		w.query(t.Array.Query)
+	}
+
+	if t.Unary != nil {
+		w.term(t.Unary.Term)
+	}
+
+	if t.If != nil {
+		w.query(t.If.Cond)
+		w.query(t.If.Then)
+		for _, elif := range t.If.Elif {
+			w.query(elif.Cond)
+			w.query(elif.Then)
+		}
+		w.query(t.If.Else)
+	}
+
+	if t.Try != nil {
+		w.query(t.Try.Body)
+		w.query(t.Try.Catch)
+	}
+
+	if t.Reduce != nil {
+		w.term(t.Reduce.Term)
+		w.pattern(t.Reduce.Pattern)
+		w.query(t.Reduce.Start)
+		w.query(t.Reduce.Update)
+	}
+
+	if t.Foreach != nil {
+		w.term(t.Foreach.Term)
+		w.pattern(t.Foreach.Pattern)
+		w.query(t.Foreach.Start)
+		w.query(t.Foreach.Update)
+		w.query(t.Foreach.Extract)
+	}
+
+	if t.Label != nil {
+		w.query(t.Label.Body)
+	}
+
+	for _, suffix := range t.SuffixList {
+		w.index(suffix.Index)
+		if suffix.Bind != nil {
+			for _, pattern := range suffix.Bind.Patterns {
+				w.pattern(pattern)
+			}
+			w.query(suffix.Bind.Body)
+		}
+	}
+}
+
+func (w *funcPolicyWalker) index(i *gojq.Index) {
+	if i == nil {
+		return
+	}
+
+	w.str(i.Str)
+	w.query(i.Start)
+	w.query(i.End)
+}
+
+func (w *funcPolicyWalker) str(s *gojq.String) {
+	if s == nil {
+		return
+	}
+
+	for _, q := range s.Queries {
+		w.query(q)
+	}
+}
+
+func (w *funcPolicyWalker) pattern(p *gojq.Pattern) {
+	if p == nil {
+		return
+	}
+
+	for _, elem := range p.Array {
+		w.pattern(elem)
+	}
+
+	for _, obj := range p.Object {
+		w.str(obj.KeyString)
+		w.query(obj.KeyQuery)
+		w.pattern(obj.Val)
+	}
+}
+
+// The name calls are renamed to when locating them, see findCallOffset.
+const probeName = "__goque_probe"
+
+// Returns the byte offset of the call of token with arity in a filter
+// that the parsed filter checks: not an object key or a call of a
+// function the filter defines. The parsed filter has no positions, so
+// each occurrence of token is renamed in turn and the first whose
+// renamed filter calls the renamed function, variable or format is the
+// call. Falls back to the first occurrence, -1 if there is none.
+func findCallOffset(src, token string, arity int) int {
+	probe := probeName
+	if strings.HasPrefix(token, "$") || strings.HasPrefix(token, "@") {
+		probe = token[:1] + probeName
+	}
+
+	offsets := tokenOffsets(src, token)
+	for _, offset := range offsets {
+		query, err := gojq.Parse(src[:offset] + probe + src[offset+len(token):])
+		if err != nil {
+			continue
+		}
+
+		w := &funcPolicyWalker{p: &Config{}, probe: probe, probeToken: token, probeArity: arity}
+		if w.query(query); w.found {
+			return offset
+		}
+	}
+
+	if len(offsets) == 0 {
+		return -1
+	}
+	return offsets[0]
+}
+
+// Returns the byte offsets of token in a filter, skipping comments,
+// string literals (but not their interpolations), field names and
+// variables.
+func tokenOffsets(src, token string) []int {
+	var offsets []int
+
+	// Each entry is the paren depth of an interpolation, -1 for a string
+	stack := []int{0}
+
+	for i := 0; i < len(src); i++ {
+		top := &stack[len(stack)-1]
+		ch := src[i]
+
+		if *top < 0 {
+			switch {
+			case ch == '\\' && i+1 < len(src) && src[i+1] == '(':
+				stack = append(stack, 1)
+				i++
+			case ch == '\\':
+				i++
+			case ch == '"':
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		switch ch {
+		case '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case '"':
+			stack = append(stack, -1)
+			continue
+		case '(':
+			if len(stack) > 1 {
+				*top++
+			}
+		case ')':
+			if len(stack) > 1 {
+				if *top--; *top == 0 {
+					stack = stack[:len(stack)-1]
+				}
+			}
+		}
+
+		if !strings.HasPrefix(src[i:], token) {
+			continue
+		}
+
+		if i > 0 && (isIdentByte(src[i-1]) || src[i-1] == '.' || src[i-1] == '$' || src[i-1] == '@') {
+			continue
+		}
+
+		if end := i + len(token); end < len(src) && isIdentByte(src[end]) {
+			continue
+		}
+
+		offsets = append(offsets, i)
+	}
+
+	return offsets
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// Returns the 1-based line and rune column of a byte offset in src.
+func lineColumn(src string, offset int) (int, int) {
+	line := 1 + strings.Count(src[:offset], "\n")
+	lineStart := strings.LastIndexByte(src[:offset], '\n') + 1
+	return line, utf8.RuneCountInString(src[lineStart:offset]) + 1
+}
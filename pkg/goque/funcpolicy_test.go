@@ -0,0 +1,135 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFuncList(t *testing.T) {
+	assert.Nil(t, ParseFuncList(""))
+	assert.Equal(t,
+		map[string]bool{"debug": true, "error/0": true, "@sh": true, "mod::fn": true},
+		ParseFuncList("debug, error/0,@sh,mod::fn,bad name,x/y"))
+}
+
+func TestParseRouteFuncLists(t *testing.T) {
+	assert.Nil(t, ParseRouteFuncLists(""))
+	assert.Equal(t,
+		map[string]map[string]bool{"/public": {"input": true, "inputs": true, "@sh": true}, "/internal": {}},
+		ParseRouteFuncLists("debug, /public:input,inputs, bad name,@sh,/internal:,/bad,"))
+	assert.True(t, IsRouteFuncListEntry("/public:input/0"))
+	assert.True(t, IsRouteFuncListEntry("/internal:"))
+	assert.False(t, IsRouteFuncListEntry("/public"))
+	assert.False(t, IsRouteFuncListEntry("/public:x/y"))
+}
+
+func TestCheckFuncPolicy(t *testing.T) {
+	deny := ParseFuncList("input,inputs,debug,stderr,halt,repeat,@sh,error/0")
+	allow := ParseFuncList("map,select,length,tostring,not")
+
+	tests := []struct {
+		name    string
+		filter  string
+		allow   map[string]bool
+		deny    map[string]bool
+		wantErr string
+	}{
+		{name: "no policy", filter: "debug | input"},
+		{name: "allowed", filter: ".a | map(. + 1)", deny: deny},
+		{name: "denied", filter: ".a | debug", deny: deny, wantErr: "function not allowed: debug/0 at line 1, column 6"},
+		{name: "denied arity", filter: "error", deny: deny, wantErr: "function not allowed: error/0 at line 1, column 1"},
+		{name: "other arity", filter: `error("x")`, deny: deny},
+		{name: "denied format", filter: `"echo \(.a)" | @sh`, deny: deny, wantErr: "function not allowed: @sh at line 1, column 16"},
+		{name: "denied format string", filter: `@sh "echo \(.a)"`, deny: deny, wantErr: "function not allowed: @sh at line 1, column 1"},
+		{
+			name:    "nested and multiline",
+			filter:  "# debug in a comment\n{\"debug\": .debug, a: [.[] | select(. > 1)]}\n| reduce .a[] as $x (0; if $x then repeat(.) else . end)",
+			deny:    deny,
+			wantErr: "function not allowed: repeat/1 at line 3, column 36",
+		},
+		{name: "after object key", filter: "{input: 1} | input", deny: deny, wantErr: "function not allowed: input/0 at line 1, column 14"},
+		{name: "after field and definition", filter: ".debug | def f: debug; f", deny: deny, wantErr: "function not allowed: debug/0 at line 1, column 17"},
+		{name: "after other arity", filter: "def error(f): f; error", deny: deny, wantErr: "function not allowed: error/0 at line 1, column 18"},
+		{name: "in interpolation", filter: `"\(input)"`, deny: deny, wantErr: "function not allowed: input/0 at line 1, column 4"},
+		{name: "user definition shadows", filter: "def debug: .; debug", deny: deny},
+		{name: "user definition calls denied", filter: "def f: inputs; f", deny: deny, wantErr: "function not allowed: inputs/0 at line 1, column 8"},
+		{name: "user definition after use", filter: "def f: input; def input: 1; f", deny: deny, wantErr: "function not allowed: input/0 at line 1, column 8"},
+		{name: "user definition before use", filter: "def input: 1; def f: input; f", deny: deny},
+		{name: "user definition in later definition", filter: "def f: 1; def input: f; input", deny: deny},
+		{name: "builtin calling denied", filter: "[inputs]", deny: ParseFuncList("input"), wantErr: "function not allowed: inputs/0 at line 1, column 2"},
+		{name: "builtin calling denied indirectly", filter: "values", deny: ParseFuncList("empty"), wantErr: "function not allowed: values/0 at line 1, column 1"},
+		{name: "builtin calling allowed", filter: "[inputs]", deny: ParseFuncList("debug")},
+		{name: "format by name", filter: `format("sh")`, deny: deny, wantErr: "function not allowed: @sh at line 1, column 1"},
+		{name: "other format by name", filter: `format("json")`, deny: deny},
+		{name: "format at runtime", filter: `format(.f)`, deny: deny, wantErr: "function not allowed: format/1 at line 1, column 1"},
+		{name: "ENV", filter: ".a | $ENV.HOME", deny: ParseFuncList("env"), wantErr: "function not allowed: env/0 at line 1, column 6"},
+		{name: "ENV not allowlisted", filter: "$ENV", allow: allow, wantErr: "function not allowed: env/0 at line 1, column 1"},
+		{name: "param", filter: "def f(g): g; f(.)", allow: allow},
+		{name: "variable", filter: ". as $debug | $debug", deny: deny},
+		{name: "allowlisted", filter: "map(select(length > 1)) | not", allow: allow},
+		{name: "literals", filter: "null, true, false", allow: allow},
+		{name: "not allowlisted", filter: "map(keys)", allow: allow, wantErr: "function not allowed: keys/0 at line 1, column 5"},
+		{name: "deny wins", filter: "map(.)", allow: allow, deny: ParseFuncList("map"), wantErr: "function not allowed: map/1 at line 1, column 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := gojq.Parse(tt.filter)
+			assert.NoError(t, err)
+
+			err = CheckFuncPolicy(query, tt.filter, &Config{FuncAllow: tt.allow, FuncDeny: tt.deny})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandlerFuncPolicy(t *testing.T) {
+	e := _newEngine(t, func(c *Config) { c.FuncDeny = ParseFuncList("debug,input") })
+	c := _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"peanuts":true}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("x-goque-jq-filter", ".peanuts | debug")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, fiber.StatusBadRequest, c.Response().StatusCode())
+	assert.JSONEq(t, `{"type":"urn:goque:problem:function_not_allowed","title":"Function not allowed","status":400,"detail":"function not allowed: debug/0 at line 1, column 12","code":"function_not_allowed","offset":11,"line":1,"column":12}`, string(c.Response().Body()))
+}
+
+func TestHandlerRouteFuncPolicy(t *testing.T) {
+	e := _newEngine(t, func(c *Config) {
+		c.FuncDeny = ParseFuncList("debug")
+		c.RoutePolicies = map[string]RoutePolicy{
+			"/public/jq":   {FuncDeny: ParseFuncList("debug,input")},
+			"/internal/jq": {FuncDeny: map[string]bool{}},
+		}
+	})
+
+	for _, tt := range []struct {
+		path   string
+		filter string
+		status int
+	}{
+		{"/jq", ".peanuts | debug", fiber.StatusBadRequest},
+		{"/jq", "[.peanuts, input]", fiber.StatusOK},
+		{"/public/jq", "[.peanuts, input]", fiber.StatusBadRequest},
+		{"/internal/jq", ".peanuts | debug", fiber.StatusOK},
+	} {
+		c := _GetNewFiberContext()
+		c.Path(tt.path)
+
+		c.Context().Request.SetBody([]byte(`{"peanuts":true} {"peanuts":false}`))
+		c.Context().Request.Header.Add("content-type", "application/json")
+		c.Context().Request.Header.Add("x-goque-jq-filter", tt.filter)
+
+		assert.NoError(t, e.Handler()(c))
+		assert.Equal(t, tt.status, c.Response().StatusCode(), tt.path+" "+tt.filter)
+	}
+}
@@ -0,0 +1,285 @@
+package goque
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Zone names for torfc3339 must resolve in distroless images too
+	_ "time/tzdata"
+
+	"github.com/google/uuid"
+	"github.com/itchyny/gojq"
+	"github.com/rs/zerolog/log"
+)
+
+// A custom function exposed to filters with gojq.WithFunction.
+type customFunc struct {
+	minarity int
+	maxarity int
+	fn       func(any, []any) any
+}
+
+// The custom functions goque adds to jq, by name. Each can be toggled
+// with GOQUE_JQ_CUSTOM_FUNCS.
+var customFuncs = map[string]customFunc{
+	"uuid":        {0, 0, funcUUID},
+	"sha1":        {0, 0, digestFunc("sha1", sha1.New)},
+	"sha256":      {0, 0, digestFunc("sha256", sha256.New)},
+	"sha512":      {0, 0, digestFunc("sha512", sha512.New)},
+	"hmac_sha256": {1, 1, hmacFunc("hmac_sha256", sha256.New)},
+	"hmac_sha512": {1, 1, hmacFunc("hmac_sha512", sha512.New)},
+	"fromrfc3339": {0, 0, funcFromRFC3339},
+	"torfc3339":   {0, 1, funcToRFC3339},
+	"jwt_decode":  {0, 0, funcJWTDecode},
+	"parse_url":   {0, 0, funcParseURL},
+}
+
+// Returns the names of all custom functions, sorted.
+func CustomFuncNames() []string {
+	names := make([]string, 0, len(customFuncs))
+	for name := range customFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses the comma separated list of enabled custom functions. "all"
+// enables every function, "none" or an empty list disables them.
+// Unknown names are skipped with a warning. Returns a sorted list.
+func ParseCustomFuncs(s string) []string {
+	var names []string
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "" || name == "none":
+			continue
+		case name == "all":
+			return CustomFuncNames()
+		case !IsCustomFuncName(name):
+			log.Warn().Str("function", name).Msg("Unknown custom function in GOQUE_JQ_CUSTOM_FUNCS")
+		default:
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Reports whether name is a custom function, or all or none.
+func IsCustomFuncName(name string) bool {
+	return name == "all" || name == "none" || customFuncs[name].fn != nil
+}
+
+// Returns the gojq.WithFunction options for the enabled functions.
+func customFuncOptions(names []string) []gojq.CompilerOption {
+	options := make([]gojq.CompilerOption, 0, len(names))
+	for _, name := range names {
+		f := customFuncs[name]
+		options = append(options, gojq.WithFunction(name, f.minarity, f.maxarity, f.fn))
+	}
+	return options
+}
+
+func funcTypeError(name string, v any) error {
+	return fmt.Errorf("%s cannot be applied to: %s", name, gojq.Preview(v))
+}
+
+// uuid: a random (version 4) UUID.
+func funcUUID(any, []any) any {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+	return id.String()
+}
+
+// sha1, sha256, sha512: hex encoded digest of the input string.
+func digestFunc(name string, h func() hash.Hash) func(any, []any) any {
+	return func(v any, _ []any) any {
+		s, ok := v.(string)
+		if !ok {
+			return funcTypeError(name, v)
+		}
+		d := h()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+}
+
+// hmac_sha256($key), hmac_sha512($key): hex encoded HMAC of the input
+// string with a string key.
+func hmacFunc(name string, h func() hash.Hash) func(any, []any) any {
+	return func(v any, args []any) any {
+		s, ok := v.(string)
+		if !ok {
+			return funcTypeError(name, v)
+		}
+		key, ok := args[0].(string)
+		if !ok {
+			return fmt.Errorf("%s key must be a string: %s", name, gojq.Preview(args[0]))
+		}
+		mac := hmac.New(h, []byte(key))
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+}
+
+// fromrfc3339: parses an RFC 3339 timestamp with any zone offset and
+// fractional seconds into seconds since the Unix epoch.
+func funcFromRFC3339(v any, _ []any) any {
+	s, ok := v.(string)
+	if !ok {
+		return funcTypeError("fromrfc3339", v)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("fromrfc3339 cannot parse %s: %w", gojq.Preview(v), err)
+	}
+
+	if t.Nanosecond() == 0 {
+		return int(t.Unix())
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// torfc3339, torfc3339($zone): formats seconds since the Unix epoch as
+// RFC 3339 in UTC or in an IANA time zone, e.g. "Asia/Tokyo". Integers
+// too large for an int arrive as *big.Int, see Config.PreserveNumbers.
+func funcToRFC3339(v any, args []any) any {
+	var secs, nanos int64
+	switch v := v.(type) {
+	case int:
+		secs = int64(v)
+	case float64:
+		if math.IsNaN(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return fmt.Errorf("torfc3339 timestamp out of range: %s", gojq.Preview(v))
+		}
+		whole, frac := math.Modf(v)
+		secs, nanos = int64(whole), int64(frac*1e9)
+	case *big.Int:
+		if !v.IsInt64() {
+			return fmt.Errorf("torfc3339 timestamp out of range: %s", gojq.Preview(v))
+		}
+		secs = v.Int64()
+	default:
+		return funcTypeError("torfc3339", v)
+	}
+
+	loc := time.UTC
+	if len(args) > 0 {
+		zone, ok := args[0].(string)
+		if !ok {
+			return fmt.Errorf("torfc3339 zone must be a string: %s", gojq.Preview(args[0]))
+		}
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return fmt.Errorf("torfc3339 unknown zone %s", gojq.Preview(zone))
+		}
+	}
+
+	return time.Unix(secs, nanos).In(loc).Format(time.RFC3339Nano)
+}
+
+// jwt_decode: decodes the header and payload of a JWT into
+// {"header": {...}, "payload": {...}}. The signature is NOT verified.
+func funcJWTDecode(v any, _ []any) any {
+	s, ok := v.(string)
+	if !ok {
+		return funcTypeError("jwt_decode", v)
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("jwt_decode expects a JWT with 3 parts, got %d", len(parts))
+	}
+
+	decoded := make(map[string]any, 2)
+	for i, name := range []string{"header", "payload"} {
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[i], "="))
+		if err != nil {
+			return fmt.Errorf("jwt_decode cannot decode %s: %w", name, err)
+		}
+
+		var part any
+		if err := json.Unmarshal(raw, &part); err != nil {
+			return fmt.Errorf("jwt_decode cannot parse %s: %w", name, err)
+		}
+		decoded[name] = part
+	}
+
+	return decoded
+}
+
+// parse_url: splits a URL into scheme, user, password, host, port,
+// path, query (an object of arrays) and fragment. Absent parts are null.
+func funcParseURL(v any, _ []any) any {
+	s, ok := v.(string)
+	if !ok {
+		return funcTypeError("parse_url", v)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("parse_url cannot parse %s: %w", gojq.Preview(v), err)
+	}
+
+	query := make(map[string]any)
+	for key, values := range u.Query() {
+		list := make([]any, len(values))
+		for i, value := range values {
+			list[i] = value
+		}
+		query[key] = list
+	}
+
+	parsed := map[string]any{
+		"scheme":   nilIfEmpty(u.Scheme),
+		"user":     nil,
+		"password": nil,
+		"host":     nilIfEmpty(u.Hostname()),
+		"port":     nil,
+		"path":     u.Path,
+		"query":    query,
+		"fragment": nilIfEmpty(u.Fragment),
+	}
+
+	if u.User != nil {
+		parsed["user"] = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			parsed["password"] = password
+		}
+	}
+
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err == nil {
+			parsed["port"] = n
+		}
+	}
+
+	return parsed
+}
+
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
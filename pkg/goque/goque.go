@@ -0,0 +1,229 @@
+/*
+Package goque runs jq filters against JSON, NDJSON and XML request
+bodies with gojq, as the goque server does. It can be embedded in Go
+services through an Engine, which compiles, caches, evaluates and
+formats filters with the limits, policies and custom functions of a
+Config, and serves them with a fiber.Handler or a net/http
+http.Handler:
+
+	engine, err := goque.NewEngine(goque.DefaultConfig())
+	if err != nil {
+		return err
+	}
+	app.Post("/jq", engine.Handler())
+	mux.Handle("/jq", engine.HTTPHandler())
+
+Requests send the filter with the x-goque-jq-filter header, unless
+Config.Filter is set. Errors are sent as RFC 7807 problems, see
+Problem.
+*/
+package goque
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// The defaults of DefaultConfig.
+const (
+	DefaultDecompressLimit = 10 * 1024 * 1024
+	DefaultBodyLimit       = fiber.DefaultBodyLimit
+	DefaultMaxDepth        = 512
+	DefaultCacheSize       = 256
+	DefaultEvalTimeout     = 10 * time.Second
+)
+
+// The configuration of an Engine. The zero value runs any header
+// filter without limits or custom functions, see DefaultConfig for
+// the defaults of the goque server.
+type Config struct {
+	Filter          string        // Filter run when requests send none, empty requires one
+	EscapeHTML      bool          // Escape HTML in JSON results
+	DecompressLimit int64         // Max decompressed request body size in bytes, 0 is unlimited
+	BodyLimit       int           // Max request body size in bytes, 0 is unlimited
+	MaxDepth        int           // Max body nesting depth, 0 is unlimited
+	MaxArrayLength  int           // Max body array length, 0 is unlimited
+	MaxStringLength int           // Max body string length, 0 is unlimited
+	MaxResults      int           // Max values a filter may emit, 0 is unlimited
+	MaxOutputSize   int           // Max response size in bytes, 0 is unlimited
+	EvalTimeout     time.Duration // Max filter evaluation time, 0 is unlimited
+	DebugMode       bool          // Allow x-goque-debug requests
+	PreserveNumbers bool          // Decode numbers as json.Number, keeping big ints exact
+	SortKeys        bool          // Sort object keys of JSON results
+	Indent          string        // Indent of JSON results, empty for compact, see ParseIndent
+	Slurp           bool          // Combine all JSON values in the body into an array
+	NullInput       bool          // Run filters against null, the body is optional
+	CacheSize       int           // Max compiled header filters kept, 0 disables the cache
+
+	FilterPolicy    FilterPolicy           // Policy for header filters, empty allows any filter
+	FilterAllowlist map[string]bool        // Allowlisted header filter hashes and names, see FilterHash
+	RoutePolicies   map[string]RoutePolicy // Policies by request path, see RouteConfig
+	NamedFilters    map[string]string      // Filters run by name with x-goque-filter-name, see LoadNamedFilters
+
+	EnvPrefixes []string        // Env var prefixes visible to $ENV and env
+	FuncAllow   map[string]bool // Functions filters may call, nil allows all
+	FuncDeny    map[string]bool // Functions filters may not call
+	CustomFuncs []string        // Enabled custom functions, see CustomFuncNames
+	HaltStatus  map[int]int     // HTTP status by halt exit code, see HaltStatus
+}
+
+// Returns the configuration of the goque server without any env vars
+// or flags set.
+func DefaultConfig() Config {
+	return Config{
+		DecompressLimit: DefaultDecompressLimit,
+		BodyLimit:       DefaultBodyLimit,
+		MaxDepth:        DefaultMaxDepth,
+		PreserveNumbers: true,
+		CacheSize:       DefaultCacheSize,
+		EvalTimeout:     DefaultEvalTimeout,
+		FilterPolicy:    FilterPolicyAllow,
+		CustomFuncs:     CustomFuncNames(),
+		HaltStatus:      map[int]int{0: 200, 5: 422},
+	}
+}
+
+// Compiles, caches, evaluates and formats filters configured by a
+// Config. An Engine is safe for concurrent use.
+type Engine struct {
+	config Config
+	code   *gojq.Code         // The compiled Config.Filter, nil without one
+	json   jsoniter.API       // Decodes request bodies
+	cache  *filterCache       // Compiled header filters, nil if disabled
+	routes map[string]*Engine // Engines serving the paths of Config.RoutePolicies
+}
+
+// Returns an Engine for config, compiling config.Filter and checking
+// config.NamedFilters, for each route policy too. A filter that fails
+// to parse, compile or pass the function policy returns a
+// *FilterError.
+func NewEngine(config Config) (*Engine, error) {
+	e := &Engine{
+		config: config,
+		// With UseNumber, bodies decode numbers as json.Number, which
+		// gojq turns into int or *big.Int so ids above 2^53 survive
+		json: jsoniter.Config{EscapeHTML: config.EscapeHTML, UseNumber: config.PreserveNumbers}.Froze(),
+	}
+
+	if config.CacheSize > 0 {
+		e.cache = newFilterCache(config.CacheSize)
+	}
+
+	if config.Filter != "" {
+		_, code, err := e.Compile(config.Filter)
+		if err != nil {
+			return nil, err
+		}
+		e.code = code
+	}
+
+	for _, name := range NamedFilterNames(&config) {
+		if _, _, err := e.Compile(config.NamedFilters[name]); err != nil {
+			err.(*FilterError).Message = "named filter " + name + ": " + err.Error()
+			return nil, err
+		}
+	}
+
+	// Requests to a route with a policy are served by an engine of the
+	// route's configuration, so its filters are checked and cached
+	// with the route's function lists
+	paths := make([]string, 0, len(config.RoutePolicies))
+	for path := range config.RoutePolicies {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		route, err := NewEngine(config.RouteConfig(path))
+		if err != nil {
+			err.(*FilterError).Message = "route " + path + ": " + err.Error()
+			return nil, err
+		}
+
+		if e.routes == nil {
+			e.routes = make(map[string]*Engine)
+		}
+		e.routes[path] = route
+	}
+
+	return e, nil
+}
+
+// Returns the configuration of e. Its maps and slices are shared with
+// e and must not be modified.
+func (e *Engine) Config() Config {
+	return e.config
+}
+
+// Parses, checks and compiles filter with the function policy and
+// compiler options of e. input and inputs read nothing, debug and
+// stderr pass their input through. Errors are a *FilterError.
+func (e *Engine) Compile(filter string) (*gojq.Query, *gojq.Code, error) {
+	return e.CompileWith(filter)
+}
+
+// Compiles filter like Compile with options added after the options
+// of e, e.g. gojq.WithInputIter or a debug function writing to a log.
+func (e *Engine) CompileWith(filter string, options ...gojq.CompilerOption) (*gojq.Query, *gojq.Code, error) {
+	return compileFilter(filter, &e.config, append(CompilerOptions(&e.config), options...))
+}
+
+// Compiles filter like Compile, reusing the code of the last
+// Config.CacheSize filters compiled with it. Errors are not cached.
+func (e *Engine) CompileCached(filter string) (*gojq.Code, error) {
+	if code, ok := e.cache.get(filter); ok {
+		return code, nil
+	}
+
+	_, code, err := e.Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cache.add(filter, code)
+	return code, nil
+}
+
+// Runs code against input and returns the first non-null result, see
+// GetFirstValueIter. Evaluation past Config.EvalTimeout stops with a
+// 422 problem. halt and halt_error return a *HaltError, other errors
+// a *Problem.
+func (e *Engine) Evaluate(code *gojq.Code, input any) (any, error) {
+	return e.EvaluateContext(context.Background(), code, input)
+}
+
+// Runs code like Evaluate, also stopping once ctx is done, e.g. when
+// the client of a request is gone.
+func (e *Engine) EvaluateContext(ctx context.Context, code *gojq.Code, input any) (any, error) {
+	ctx, cancel := e.evalContext(ctx)
+	defer cancel()
+
+	return GetFirstValueIter(code.RunWithContext(ctx, input), e.config.MaxResults)
+}
+
+// Returns ctx with the Config.EvalTimeout deadline. gojq checks the
+// context between instructions, so a filter building a large value,
+// e.g. [range(1e8)], is stopped while it runs.
+func (e *Engine) evalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.config.EvalTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, e.config.EvalTimeout)
+}
+
+// Returns the output format of results when requests don't set one.
+func (e *Engine) OutputFormat() OutputFormat {
+	return OutputFormat{SortKeys: e.config.SortKeys, Indent: e.config.Indent}
+}
+
+// Encodes v as JSON in format f, stopping with a 507 problem once the
+// output passes Config.MaxOutputSize.
+func (e *Engine) Format(v any, f OutputFormat) ([]byte, error) {
+	return e.encodeJSON(v, f)
+}
@@ -0,0 +1,72 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEngine(t *testing.T) {
+	e, err := NewEngine(DefaultConfig())
+	assert.NoError(t, err)
+	assert.Nil(t, e.code)
+
+	_, err = NewEngine(Config{Filter: ".a |"})
+	assert.EqualError(t, err, "unexpected EOF")
+	assert.Equal(t, ErrFilterParse, err.(*FilterError).Code)
+
+	_, err = NewEngine(Config{Filter: "input", FuncDeny: ParseFuncList("input")})
+	assert.Equal(t, ErrFuncNotAllowed, err.(*FilterError).Code)
+}
+
+func TestEngineCompileCached(t *testing.T) {
+	e := _newEngine(t, func(c *Config) { c.CacheSize = 1 })
+
+	a, err := e.CompileCached(".a")
+	assert.NoError(t, err)
+
+	code, err := e.CompileCached(".a")
+	assert.NoError(t, err)
+	assert.Same(t, a, code)
+
+	_, err = e.CompileCached("(.b")
+	assert.Error(t, err)
+	assert.Equal(t, 1, e.cache.len())
+
+	_, err = e.CompileCached(".b")
+	assert.NoError(t, err)
+
+	code, err = e.CompileCached(".a")
+	assert.NoError(t, err)
+	assert.NotSame(t, a, code)
+
+	// Without a cache every filter is compiled again
+	e = _newEngine(t, func(c *Config) { c.CacheSize = 0 })
+
+	a, _ = e.CompileCached(".a")
+	code, _ = e.CompileCached(".a")
+	assert.NotSame(t, a, code)
+}
+
+func TestEngineEvaluateFormat(t *testing.T) {
+	e := _newEngine(t, func(c *Config) {
+		c.SortKeys = true
+		c.MaxOutputSize = 16
+	})
+
+	_, code, err := e.Compile(`{b: .a, a: .a}`)
+	assert.NoError(t, err)
+
+	out, err := e.Evaluate(code, map[string]any{"a": 1})
+	assert.NoError(t, err)
+
+	raw, err := e.Format(out, e.OutputFormat())
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1,"b":1}`, string(raw))
+
+	_, err = e.Format(out, OutputFormat{Indent: "  "})
+	assert.Equal(t, ErrOutputLimit, err.(*Problem).Code)
+
+	_, err = e.Evaluate(code, "nope.")
+	assert.Equal(t, ErrFilterRuntime, err.(*Problem).Code)
+}
@@ -0,0 +1,120 @@
+package goque
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The status for halt exit codes that are neither mapped nor an HTTP
+// status.
+const defaultHaltFallbackStatus = fiber.StatusUnprocessableEntity
+
+// Returned when a filter stops with halt or halt_error.
+type HaltError struct {
+	ExitCode int // 0 for halt, 5 for halt_error, or the code passed to halt_error
+	Value    any // The value passed to halt_error, nil for halt
+}
+
+func (e *HaltError) Error() string {
+	return "filter halted with exit code " + strconv.Itoa(e.ExitCode)
+}
+
+// Returns the *HaltError for a gojq halt or halt_error error. Errors
+// raised with error(...) are not halts.
+func asHaltError(err error) (*HaltError, bool) {
+	e, ok := err.(interface {
+		IsHaltError() bool
+		ExitCode() int
+		Value() any
+	})
+	if !ok || !e.IsHaltError() {
+		return nil, false
+	}
+
+	return &HaltError{ExitCode: e.ExitCode(), Value: e.Value()}, true
+}
+
+// Parses a comma separated list of exit code to HTTP status mappings,
+// e.g. "0:200,5:422". Statuses must be 200-599. Invalid entries are
+// skipped with a warning.
+// Returns nil for an empty list.
+func ParseHaltStatus(s string) map[int]int {
+	var statuses map[int]int
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parsedCode, parsedStatus, ok := ParseHaltStatusEntry(entry)
+		if !ok {
+			log.Warn().Str("entry", entry).Msg("Invalid halt status entry, expected exitcode:status")
+			continue
+		}
+
+		if statuses == nil {
+			statuses = make(map[int]int)
+		}
+		statuses[parsedCode] = parsedStatus
+	}
+
+	return statuses
+}
+
+// Parses an exitcode:status entry of a halt status list.
+func ParseHaltStatusEntry(entry string) (int, int, bool) {
+	code, status, ok := strings.Cut(entry, ":")
+	parsedCode, err := strconv.Atoi(strings.TrimSpace(code))
+	parsedStatus, statusErr := strconv.Atoi(strings.TrimSpace(status))
+	if !ok || err != nil || statusErr != nil || !isHTTPStatus(parsedStatus) {
+		return 0, 0, false
+	}
+
+	return parsedCode, parsedStatus, true
+}
+
+// Reports whether status can be mapped to a halt exit code: a final
+// response status, 200-599.
+func isHTTPStatus(status int) bool {
+	return status >= 200 && status <= 599
+}
+
+// Reports whether a halt value is sent with status. 204 and 304
+// responses have no body.
+func haltBodyAllowed(status int) bool {
+	return status != fiber.StatusNoContent && status != fiber.StatusNotModified
+}
+
+// Returns the HTTP status for a halt exit code. Mapped codes use
+// p.HaltStatus, unmapped codes that are an error status (400-599) are
+// used as is, e.g. halt_error(403), anything else is 422 so filters
+// can't send a 1xx, 2xx or 3xx response on their own.
+func HaltStatus(p *Config, exitCode int) int {
+	if status, ok := p.HaltStatus[exitCode]; ok {
+		return status
+	}
+
+	if exitCode >= 400 && exitCode <= 599 {
+		return exitCode
+	}
+
+	return defaultHaltFallbackStatus
+}
+
+// Sends the halt value with the status mapped from its exit code. The
+// value is rendered like a result; halt, halt_error(null) and statuses
+// without a body (e.g. a code mapped to 204) send an empty body.
+func (e *Engine) sendHalt(x exchange, h *HaltError) error {
+	status := HaltStatus(&e.config, h.ExitCode)
+	x.Status(status)
+
+	if h.Value == nil || !haltBodyAllowed(status) {
+		return nil
+	}
+
+	return e.sendResult(x, h.Value)
+}
@@ -0,0 +1,59 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHaltStatus(t *testing.T) {
+	assert.Nil(t, ParseHaltStatus(""))
+	assert.Nil(t, ParseHaltStatus("nope."))
+	assert.Equal(t, map[int]int{0: 204, 1: 409}, ParseHaltStatus(" 0:204, 1 : 409, 2:999, 3, 4:101"))
+}
+
+func TestHaltStatus(t *testing.T) {
+	p := &Config{HaltStatus: ParseHaltStatus("1:409")}
+
+	assert.Equal(t, fiber.StatusConflict, HaltStatus(p, 1))
+	assert.Equal(t, fiber.StatusForbidden, HaltStatus(p, 403))
+	assert.Equal(t, fiber.StatusUnprocessableEntity, HaltStatus(p, 2))
+
+	// Only error statuses pass through unmapped
+	for _, exitCode := range []int{100, 204, 302, 399, 600} {
+		assert.Equal(t, fiber.StatusUnprocessableEntity, HaltStatus(p, exitCode), exitCode)
+	}
+	assert.Equal(t, fiber.StatusServiceUnavailable, HaltStatus(p, 503))
+}
+
+func TestHandlerHalt(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		resCode int
+		resBody string
+	}{
+		{"halt", `halt`, fiber.StatusOK, ``},
+		{"halt_error", `"nope." | halt_error`, fiber.StatusUnprocessableEntity, `"nope."`},
+		{"http status", `{reason: "nope."} | halt_error(403)`, fiber.StatusForbidden, `{"reason":"nope."}`},
+		{"mapped", `.peanuts | halt_error(1)`, fiber.StatusConflict, `true`},
+		{"unmapped", `.peanuts | halt_error(2)`, fiber.StatusUnprocessableEntity, `true`},
+		{"after null", `null, (null | halt_error(1))`, fiber.StatusConflict, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := _newEngine(t, func(c *Config) { c.HaltStatus = ParseHaltStatus("0:200,5:422,1:409") })
+			c := _GetNewFiberContext()
+
+			c.Context().Request.SetBody([]byte(`{"peanuts":true}`))
+			c.Context().Request.Header.Add("content-type", "application/json")
+			c.Context().Request.Header.Add("x-goque-jq-filter", tt.filter)
+
+			assert.NoError(t, e.Handler()(c))
+			assert.Equal(t, tt.resCode, c.Response().StatusCode())
+			assert.Equal(t, tt.resBody, string(c.Response().Body()))
+		})
+	}
+}
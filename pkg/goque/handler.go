@@ -0,0 +1,423 @@
+package goque
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/itchyny/gojq"
+	"go.opentelemetry.io/otel/metric/global"
+)
+
+var meter = global.Meter("goque")
+
+// Returns the compiler options shared by the configured filter and
+// header filters, so both run in the same environment.
+func CompilerOptions(p *Config) []gojq.CompilerOption {
+	options := []gojq.CompilerOption{
+		gojq.WithEnvironLoader(SandboxedEnviron(p.EnvPrefixes)),
+		gojq.WithInputIter(gojq.NewIter()),
+	}
+	options = append(options, passthroughDebugOptions...)
+
+	return append(options, customFuncOptions(p.CustomFuncs)...)
+}
+
+// Returns the code of filter for a request. Requests without debug or
+// values left for input and inputs share the cached code, the others
+// compile filter with debug and stderr capturing into dbg and input
+// and inputs reading the remaining body values.
+func (e *Engine) requestCode(filter string, dbg *debugCollector, inputs []any) (*gojq.Code, error) {
+	if dbg == nil && len(inputs) == 0 {
+		return e.CompileCached(filter)
+	}
+
+	options := []gojq.CompilerOption{gojq.WithInputIter(gojq.NewIter(inputs...))}
+	if dbg != nil {
+		options = append(options, dbg.compilerOptions()...)
+	}
+
+	_, code, err := e.CompileWith(filter, options...)
+	return code, err
+}
+
+// Returns the first non-null value in the iter. If maxResults is
+// positive, the values after it are counted too, and iteration stops
+// with an error once the filter has emitted more values than that.
+// An iter run with a context past its deadline returns a 422 problem.
+// halt and halt_error return a *HaltError. Other errors after the
+// first non-null value end the iteration without being reported.
+func GetFirstValueIter(iter gojq.Iter, maxResults int) (any, error) {
+	var out any
+	count := 0
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, OutputLimitError("time", ErrTimeLimit, "Filter evaluation exceeded the time limit")
+			}
+			if out != nil {
+				break
+			}
+			if h, ok := asHaltError(err); ok {
+				return nil, h
+			}
+			return nil, RuntimeProblem(err)
+		}
+		if count++; maxResults > 0 && count > maxResults {
+			return nil, OutputLimitError("results", ErrResultLimit,
+				"Filter emitted more than "+strconv.Itoa(maxResults)+" results")
+		}
+		if out == nil && v != nil {
+			if out = v; maxResults <= 0 {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Returns the handler for jq evaluation requests. If Config.Filter is
+// set, its compiled code is used here. If the x-goque-jq-filter
+// header is set, the filter is parsed and ran against the body, and
+// x-goque-filter-name runs one of Config.NamedFilters. Both take
+// priority over Config.Filter, unless forbidden by the header filter
+// policy of the route (403). With Config.DebugMode,
+// x-goque-debug: true returns the debug and stderr messages,
+// evaluation time and result count, see debug.go. Errors are sent as
+// application/problem+json, see HandleError. XML bodies are converted
+// to JSON before evaluation, see ParseXML. Slurp and null input modes
+// are selected with GetInputMode. HTTPHandler serves the same
+// requests with net/http.
+func (e *Engine) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return e.handlePost(fiberExchange{c})
+	}
+}
+
+func (e *Engine) handlePost(x exchange) error {
+	if route, ok := e.routes[x.Path()]; ok {
+		return route.handlePost(x)
+	}
+
+	p := &e.config
+
+	mode, err := GetInputMode(x, p)
+	if err != nil {
+		return e.sendProblem(x, err)
+	}
+
+	// Parse the body into values. The filter runs on the first, the
+	// rest are read with input and inputs. Null input runs on null and
+	// leaves every value to input, the body is optional.
+	var body any
+	var inputs []any
+	if !mode.NullInput || len(x.Body()) > 0 {
+		inputs, err = e.parseBody(x, mode.Slurp)
+
+		// 400 if bad body, or the status of the problem
+		if err != nil {
+			return e.sendProblem(x, err)
+		}
+	}
+
+	if !mode.NullInput {
+		body, inputs = inputs[0], inputs[1:]
+	}
+
+	// Capture debug and stderr if asked for and allowed
+	var dbg *debugCollector
+	if debugRequested(x, p) {
+		dbg = &debugCollector{Messages: []any{}}
+	}
+
+	// If jq filter header is set, prioritize over compiled code
+	jqHeader, name, err := e.headerFilter(x)
+	if err != nil {
+		return e.sendProblem(x, err)
+	}
+	if jqHeader != "" {
+		if err := checkHeaderFilter(x, p, jqHeader, name); err != nil {
+			return e.sendProblem(x, err)
+		}
+
+		code, err := e.requestCode(jqHeader, dbg, inputs)
+		if err != nil {
+			return e.sendProblem(x, err)
+		}
+
+		return e.runFilter(x, code, body, dbg)
+	}
+
+	// If the configured filter was compiled run it
+	if e.code != nil {
+		code := e.code
+
+		// The compiled code has no capturing debug and reads no inputs,
+		// compile it again if the request needs them
+		if dbg != nil || len(inputs) > 0 {
+			var err error
+			if code, err = e.requestCode(p.Filter, dbg, inputs); err != nil {
+				return e.sendProblem(x, err)
+			}
+		}
+
+		return e.runFilter(x, code, body, dbg)
+	}
+
+	// jq filter nor configured filter was provided
+	return e.sendProblem(x, NewProblem(ErrMissingFilter, "A JQ filter was not sent with request"))
+}
+
+// Returns the filter sent with x-goque-jq-filter, see
+// decodeHeaderFilter, or the named filter picked with
+// x-goque-filter-name and its name. Both headers are a 400 problem, an
+// unknown name a 404 problem.
+func (e *Engine) headerFilter(h Header) (string, string, error) {
+	filter, name := h.Get("x-goque-jq-filter"), h.Get("x-goque-filter-name")
+	filter, err := decodeHeaderFilter(filter, h.Get("x-goque-jq-filter-encoding"))
+	if err != nil {
+		return "", "", err
+	}
+	if name == "" {
+		return filter, "", nil
+	}
+	if filter != "" {
+		return "", "", NewProblem(ErrInvalidRequest, "Send either x-goque-jq-filter or x-goque-filter-name")
+	}
+
+	named, ok := e.config.NamedFilters[name]
+	if !ok {
+		return "", "", NewProblem(ErrNotFound, "Unknown filter name: "+name)
+	}
+
+	return named, name, nil
+}
+
+// Decodes a filter sent with x-goque-jq-filter-encoding. Header values
+// can't hold newlines and only portably hold ASCII, so clients sending
+// any filter as typed percent-encode its UTF-8, e.g. with JavaScript's
+// encodeURIComponent. Filter hashes are taken of the decoded filter.
+// An unknown encoding or invalid filter is a 400 problem.
+func decodeHeaderFilter(filter string, encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "":
+		return filter, nil
+	case "percent":
+		decoded, err := url.PathUnescape(filter)
+		if err != nil || !utf8.ValidString(decoded) {
+			return "", NewProblem(ErrInvalidRequest, "x-goque-jq-filter is not percent-encoded UTF-8")
+		}
+		return decoded, nil
+	}
+
+	return "", NewProblem(ErrInvalidRequest, "x-goque-jq-filter-encoding must be percent")
+}
+
+// Parses the request body into its values, see ParseBody.
+func (e *Engine) parseBody(x exchange, slurp bool) ([]any, error) {
+	return e.ParseBody(x.Body(), x.Get(fiber.HeaderContentType), x.Get(fiber.HeaderContentEncoding), slurp)
+}
+
+// Parses a request body sent with contentType and contentEncoding into
+// its values, as the handlers do. Compressed bodies are decompressed
+// up to Config.DecompressLimit bytes. XML content types are converted
+// with ParseXML, JSON content types are decoded as one value, or as a
+// stream of values if the body holds several (e.g. NDJSON lines).
+// With slurp, every JSON value in the body is combined into one array.
+// Each value is checked with CheckBodyLimits, numbers beyond the float
+// range are invalid. Errors are a *Problem.
+func (e *Engine) ParseBody(raw []byte, contentType string, contentEncoding string, slurp bool) ([]any, error) {
+	p := &e.config
+
+	if p.BodyLimit > 0 && len(raw) > p.BodyLimit {
+		return nil, NewProblem(ErrBodyTooLarge, "Request body exceeds the limit of "+strconv.Itoa(p.BodyLimit)+" bytes")
+	}
+
+	data, err := DecompressBody(raw, contentEncoding, p.DecompressLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	ctype := utils.ParseVendorSpecificContentType(utils.ToLower(contentType))
+
+	var values []any
+	if IsXMLMediaType(ctype) {
+		var body any
+		body, err = ParseXML(data)
+		if values = []any{body}; slurp {
+			values = []any{[]any{body}}
+		}
+	} else if IsJSONMediaType(ctype) && slurp {
+		var body []any
+		body, err = DecodeJSONValues(data, p.PreserveNumbers)
+		values = []any{body}
+	} else if IsJSONMediaType(ctype) {
+		values, err = e.decodeJSONBody(data)
+	} else {
+		return nil, NewProblem(ErrUnsupportedMediaType, "Unsupported Content-Type: "+ctype)
+	}
+
+	if err != nil {
+		return nil, NewProblem(ErrInvalidBody, err.Error())
+	}
+
+	for _, v := range values {
+		if err := CheckBodyLimits(v, p); err != nil {
+			return nil, err
+		}
+		if err := checkNumbers(v, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// Decodes a JSON body as one value. Bodies holding a stream of values
+// fail to decode as one and are decoded with DecodeJSONValues instead.
+// Invalid bodies return the error of decoding one value.
+func (e *Engine) decodeJSONBody(data []byte) ([]any, error) {
+	var body any
+	err := e.json.Unmarshal(data, &body)
+	if err == nil {
+		return []any{body}, nil
+	}
+
+	if values, streamErr := DecodeJSONValues(data, e.config.PreserveNumbers); streamErr == nil && len(values) > 1 {
+		return values, nil
+	}
+
+	return nil, err
+}
+
+// Runs code against body and sends the outcome, see sendOutput. With
+// dbg set the run is recorded and sent with the debug info.
+func (e *Engine) runFilter(x exchange, code *gojq.Code, body any, dbg *debugCollector) error {
+	if dbg != nil {
+		ctx, cancel := e.evalContext(x.Context())
+		defer cancel()
+
+		out, err := dbg.run(ctx, code, body, e.config.MaxResults)
+		return e.sendDebug(x, dbg, out, err)
+	}
+
+	out, err := e.EvaluateContext(x.Context(), code, body)
+	return e.sendOutput(x, out, err)
+}
+
+// Sends the outcome of running a filter: the result, the halt value
+// with its mapped status (see sendHalt) or the error as a problem.
+func (e *Engine) sendOutput(x exchange, out any, err error) error {
+	if h, ok := err.(*HaltError); ok {
+		return e.sendHalt(x, h)
+	}
+
+	if err != nil {
+		return e.sendProblem(x, err)
+	}
+
+	return e.sendResult(x, out)
+}
+
+// Sends the jq result in the format negotiated with the Accept header,
+// see NegotiateMediaType. JSON is preferred and rendered in the format
+// from GetOutputFormat, XML is rendered with EncodeXML. Results larger
+// than Config.MaxOutputSize bytes are replaced with a 507 error,
+// stopping before or while encoding them.
+func (e *Engine) sendResult(x exchange, out any) error {
+	accepted := NegotiateMediaType(x.Get(fiber.HeaderAccept), fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMETextXML)
+
+	var raw []byte
+	var err error
+	if IsXMLMediaType(accepted) {
+		raw, err = e.encodeXML(out)
+		accepted += "; charset=utf-8"
+	} else {
+		accepted = fiber.MIMEApplicationJSON
+
+		var format OutputFormat
+		if format, err = GetOutputFormat(x, &e.config); err != nil {
+			return e.sendProblem(x, err)
+		}
+		raw, err = e.encodeJSON(out, format)
+	}
+
+	if err != nil {
+		// Problems from the encoders and limits, anything else is an
+		// internal error
+		return e.sendProblem(x, err)
+	}
+
+	x.Set(fiber.HeaderContentType, accepted)
+	return x.Send(raw)
+}
+
+// Returns a 507 problem if the JSON of v is certain to be larger than
+// Config.MaxOutputSize, see minJSONSize, before encoding it.
+func (e *Engine) checkMinOutputSize(v any) error {
+	if e.config.MaxOutputSize > 0 && minJSONSize(v, e.config.MaxOutputSize) > e.config.MaxOutputSize {
+		return e.outputSizeError()
+	}
+
+	return nil
+}
+
+func (e *Engine) outputSizeError() error {
+	return OutputLimitError("size", ErrOutputLimit,
+		"Result exceeds the output limit of "+strconv.Itoa(e.config.MaxOutputSize)+" bytes")
+}
+
+// Encodes v as JSON in format f, see writeJSON, stopping with a 507
+// problem before encoding if v is certain to be too large, or once the
+// output passes Config.MaxOutputSize.
+func (e *Engine) encodeJSON(v any, f OutputFormat) ([]byte, error) {
+	if e.config.MaxOutputSize <= 0 {
+		var buf bytes.Buffer
+		if err := e.writeJSON(&buf, v, f); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	if err := e.checkMinOutputSize(v); err != nil {
+		return nil, err
+	}
+
+	buf := &limitedBuffer{limit: e.config.MaxOutputSize}
+	if err := e.writeJSON(buf, v, f); err != nil {
+		if errors.Is(err, errOutputLimit) {
+			return nil, e.outputSizeError()
+		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encodes v with EncodeXML, stopping with a 507 problem once the
+// output passes Config.MaxOutputSize.
+func (e *Engine) encodeXML(v any) ([]byte, error) {
+	if e.config.MaxOutputSize <= 0 {
+		return EncodeXML(v)
+	}
+
+	buf := &limitedBuffer{limit: e.config.MaxOutputSize}
+	if err := writeXML(buf, v); err != nil {
+		if errors.Is(err, errOutputLimit) {
+			return nil, e.outputSizeError()
+		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
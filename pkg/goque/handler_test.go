@@ -1,10 +1,10 @@
-package main
+package goque
 
 import (
-	"os"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
@@ -21,12 +21,37 @@ func _GetNewFiberContext() *fiber.Ctx {
 	return app.AcquireCtx(&fasthttp.RequestCtx{})
 }
 
+// Returns an engine with the default configuration, changed by
+// configure if set.
+func _newEngine(t *testing.T, configure func(*Config)) *Engine {
+	config := DefaultConfig()
+	if configure != nil {
+		configure(&config)
+	}
+
+	e, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return e
+}
+
+// Compiles filter with the default configuration, changed by
+// configure if set.
+func _compileJQCode(t *testing.T, filter string, configure func(*Config)) *gojq.Code {
+	_, code, err := _newEngine(t, configure).Compile(filter)
+	if err != nil {
+		t.Fatalf("Compile(%q) error = %v", filter, err)
+	}
+	return code
+}
+
 func _CompileAssertEqual(t *testing.T, jm JQMatch) {
 	json := jsoniter.Config{
 		EscapeHTML: false,
 	}.Froze()
 
-	code := CompileJQCode(jm.filter)
+	code := _compileJQCode(t, jm.filter, nil)
 	assert.NotNil(t, code)
 
 	var inputBytes = []byte(jm.input)
@@ -53,8 +78,6 @@ func _CompileAssertEqual(t *testing.T, jm JQMatch) {
 
 func TestCompileJQ(t *testing.T) {
 
-	// TODO: Test fatal?
-
 	/* Valid Entries */
 	_CompileAssertEqual(t, JQMatch{filter: ".", input: "\"test\"", output: "\"test\""})
 	_CompileAssertEqual(t, JQMatch{filter: ".test", input: "{\"test\":\"2\"}", output: "\"2\""})
@@ -66,48 +89,45 @@ func TestCompileJQ(t *testing.T) {
 }
 
 func TestHandlerNoConfiguredJQ(t *testing.T) {
-	args := []string{os.Args[0]}
-	gp := _resetGetGoqueParamsFromStr(args)
+	e := _newEngine(t, nil)
 	c := _GetNewFiberContext()
 
 	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
 	c.Context().Request.Header.Add("content-type", "application/json")
 
-	HandlePost(c, gp)
+	e.Handler()(c)
 
 	body := string(c.Response().Body())
 
-	assert.Equal(t, "{\"message\":\"A JQ filter was not sent with request\",\"status\":\"error\"}", body)
+	assert.JSONEq(t, `{"type":"urn:goque:problem:missing_filter","title":"Missing filter","status":400,"detail":"A JQ filter was not sent with request","code":"missing_filter"}`, body)
 	assert.Equal(t, c.Response().StatusCode(), fiber.StatusBadRequest)
 }
 
 func TestHandlerImproperJQFilter(t *testing.T) {
-	args := []string{os.Args[0]}
-	gp := _resetGetGoqueParamsFromStr(args)
+	e := _newEngine(t, nil)
 	c := _GetNewFiberContext()
 
 	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
 	c.Context().Request.Header.Add("content-type", "application/json")
 	c.Context().Request.Header.Add("x-goque-jq-filter", "(wut")
 
-	assert.NoError(t, HandlePost(c, gp))
+	assert.NoError(t, e.Handler()(c))
 
 	body := string(c.Response().Body())
 
-	assert.Equal(t, "{\"message\":\"unexpected EOF\",\"status\":\"error\"}", body)
+	assert.JSONEq(t, `{"type":"urn:goque:problem:filter_parse_error","title":"Filter parse error","status":400,"detail":"unexpected EOF","code":"filter_parse_error","offset":4,"line":1,"column":5}`, body)
 	assert.Equal(t, c.Response().StatusCode(), fiber.StatusBadRequest)
 }
 
 func TestHandlerSuccessHeader(t *testing.T) {
-	args := []string{os.Args[0]}
-	gp := _resetGetGoqueParamsFromStr(args)
+	e := _newEngine(t, nil)
 	c := _GetNewFiberContext()
 
 	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
 	c.Context().Request.Header.Add("content-type", "application/json")
 	c.Context().Request.Header.Add("x-goque-jq-filter", ".peanuts")
 
-	assert.NoError(t, HandlePost(c, gp))
+	assert.NoError(t, e.Handler()(c))
 
 	body := string(c.Response().Body())
 
@@ -116,15 +136,13 @@ func TestHandlerSuccessHeader(t *testing.T) {
 }
 
 func TestHandlerSuccessCompiled(t *testing.T) {
-	args := []string{os.Args[0]}
-	gp := _resetGetGoqueParamsFromStr(args)
-	gp.code = CompileJQCode(".peanuts")
+	e := _newEngine(t, func(c *Config) { c.Filter = ".peanuts" })
 	c := _GetNewFiberContext()
 
 	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
 	c.Context().Request.Header.Add("content-type", "application/json")
 
-	assert.NoError(t, HandlePost(c, gp))
+	assert.NoError(t, e.Handler()(c))
 
 	body := string(c.Response().Body())
 
@@ -0,0 +1,35 @@
+package goque
+
+import (
+	"io"
+	"net/http"
+)
+
+// Returns a net/http handler for jq evaluation requests with the same
+// headers, errors and formats as Handler, for any method and path.
+// Mount it at the route of the jq endpoint, e.g.
+// mux.Handle("/jq", engine.HTTPHandler()) or r.Post("/jq", ...) with
+// chi. Wrap it with CompressHTTPResponse to compress responses.
+func (e *Engine) HTTPHandler() http.Handler {
+	return http.HandlerFunc(e.serveHTTP)
+}
+
+func (e *Engine) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	x := &httpExchange{w: w, r: r, status: http.StatusOK}
+	defer x.finish()
+
+	// One byte over the limit is enough for parseBody to reject it
+	var body io.Reader = r.Body
+	if e.config.BodyLimit > 0 {
+		body = io.LimitReader(r.Body, int64(e.config.BodyLimit)+1)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		e.sendProblem(x, NewProblem(ErrInvalidBody, err.Error()))
+		return
+	}
+	x.body = data
+
+	e.handlePost(x)
+}
@@ -0,0 +1,43 @@
+package goque
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	h := _newEngine(t, func(c *Config) { c.BodyLimit = 64 }).HTTPHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/jq", strings.NewReader(`{"peanuts":true}`))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("x-goque-jq-filter", ".peanuts")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	assert.Equal(t, fiber.StatusOK, res.Code)
+	assert.Equal(t, fiber.MIMEApplicationJSON, res.Header().Get("content-type"))
+	assert.Equal(t, "true", res.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/jq", strings.NewReader(`{"peanuts":true}`))
+	req.Header.Set("content-type", "application/json")
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	assert.Equal(t, fiber.StatusBadRequest, res.Code)
+	assert.Equal(t, MIMEApplicationProblemJSON, res.Header().Get("content-type"))
+	assert.Contains(t, res.Body.String(), `"code":"missing_filter"`)
+
+	req = httptest.NewRequest(http.MethodPost, "/jq", strings.NewReader(`"`+strings.Repeat("a", 64)+`"`))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("x-goque-jq-filter", ".")
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, res.Code)
+	assert.Contains(t, res.Body.String(), `"code":"body_too_large"`)
+}
@@ -0,0 +1,142 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How the request body becomes the filter input.
+type InputMode struct {
+	Slurp     bool // Combine all JSON values in the body into one array, as jq -s
+	NullInput bool // Run the filter against null without a body, as jq -n
+}
+
+// Returns the input mode of p, overridden by the x-goque-slurp and
+// x-goque-null-input headers. Invalid headers return a 400 problem.
+func GetInputMode(h Header, p *Config) (InputMode, error) {
+	mode := InputMode{Slurp: p.Slurp, NullInput: p.NullInput}
+
+	for _, m := range []struct {
+		header string
+		val    *bool
+	}{
+		{"x-goque-slurp", &mode.Slurp},
+		{"x-goque-null-input", &mode.NullInput},
+	} {
+		if v := h.Get(m.header); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return mode, NewProblem(ErrInvalidRequest, m.header+" must be true or false")
+			}
+			*m.val = parsed
+		}
+	}
+
+	return mode, nil
+}
+
+// The input modes of ParseRouteInputModes, by name.
+var inputModeNames = map[string]func(*InputMode){
+	"slurp": func(m *InputMode) { m.Slurp = true },
+	"null":  func(m *InputMode) { m.NullInput = true },
+}
+
+func isInputModeName(name string) bool {
+	return inputModeNames[name] != nil
+}
+
+// Parses a comma separated list of input modes by route path, e.g.
+// "/bulk/jq:slurp,/render/jq:null,slurp,/plain/jq:". An entry starting
+// with '/' is a path, a colon and the first mode of the route, slurp
+// or null, the entries up to the next path are its other modes. Modes
+// not listed are off for the route, headers still override them.
+// Invalid entries are skipped with a warning. Returns nil for an empty
+// list.
+func ParseRouteInputModes(s string) map[string]InputMode {
+	var modes map[string]InputMode
+
+	for path, names := range parseRouteLists(s, isInputModeName, "slurp or null") {
+		var mode InputMode
+		for _, name := range names {
+			inputModeNames[name](&mode)
+		}
+
+		if modes == nil {
+			modes = make(map[string]InputMode)
+		}
+		modes[path] = mode
+	}
+
+	return modes
+}
+
+// Reports whether entry is valid in a list parsed by
+// ParseRouteInputModes.
+func IsRouteInputModeEntry(entry string) bool {
+	return isRouteListEntry(entry, isInputModeName)
+}
+
+// Reports whether a normalized Content-Type is JSON or newline
+// delimited JSON.
+func IsJSONMediaType(ctype string) bool {
+	for _, t := range []string{fiber.MIMEApplicationJSON, "application/x-ndjson", "application/ndjson", "application/jsonl"} {
+		if strings.HasPrefix(ctype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the numbers decoded as json.Number in v. gojq converts those
+// with a fraction or exponent to float64 and those out of its range to
+// infinity, which cannot be encoded as JSON, so they return a 400
+// *Problem naming the path of the number.
+func checkNumbers(v any, path []any) error {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			if _, err := v.Float64(); err != nil {
+				return NewProblem(ErrInvalidBody, "Number "+string(v)+" is out of range at "+formatPath(path))
+			}
+		}
+	case []any:
+		for i, item := range v {
+			if err := checkNumbers(item, append(path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for k, item := range v {
+			if err := checkNumbers(item, append(path, k)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decodes every JSON value in data, e.g. concatenated values or NDJSON
+// lines. With useNumber, numbers are decoded as json.Number.
+func DecodeJSONValues(data []byte, useNumber bool) ([]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if useNumber {
+		dec.UseNumber()
+	}
+
+	values := []any{}
+	for {
+		var v any
+		if err := dec.Decode(&v); err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+}
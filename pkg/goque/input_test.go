@@ -0,0 +1,44 @@
+package goque
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSONValues(t *testing.T) {
+	values, err := DecodeJSONValues([]byte("{\"a\":1}\n{\"a\":2} 3 \"four\"\n"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"a": json.Number("1")},
+		map[string]any{"a": json.Number("2")},
+		json.Number("3"),
+		"four",
+	}, values)
+
+	values, err = DecodeJSONValues([]byte(" \n"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{}, values)
+
+	_, err = DecodeJSONValues([]byte(`{"a":1} {"a":`), false)
+	assert.Error(t, err)
+}
+
+func TestParseRouteInputModes(t *testing.T) {
+	assert.Nil(t, ParseRouteInputModes(""))
+	assert.Equal(t,
+		map[string]InputMode{"/bulk": {Slurp: true, NullInput: true}, "/render": {NullInput: true}, "/plain": {}},
+		ParseRouteInputModes("slurp, /bulk:slurp,null,/render:null,stream,/plain:"))
+	assert.True(t, IsRouteInputModeEntry("/render:null"))
+	assert.False(t, IsRouteInputModeEntry("/render"))
+	assert.False(t, IsRouteInputModeEntry("stream"))
+}
+
+func TestRouteConfigInputMode(t *testing.T) {
+	c := Config{Slurp: true, RoutePolicies: map[string]RoutePolicy{"/render": {Input: &InputMode{NullInput: true}}}}
+
+	assert.True(t, c.RouteConfig("/jq").Slurp)
+	assert.False(t, c.RouteConfig("/render").Slurp)
+	assert.True(t, c.RouteConfig("/render").NullInput)
+}
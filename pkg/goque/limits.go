@@ -0,0 +1,180 @@
+package goque
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/itchyny/gojq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/instrument"
+)
+
+// Checks a decoded body against the nesting depth, array length and
+// string length limits in p. A limit of 0 disables the check. The
+// returned error is a 422 *Problem naming the path
+// of the offending value.
+func CheckBodyLimits(v any, p *Config) error {
+	if p.MaxDepth <= 0 && p.MaxArrayLength <= 0 && p.MaxStringLength <= 0 {
+		return nil
+	}
+
+	return checkBodyLimits(v, p, 1, nil)
+}
+
+func checkBodyLimits(v any, p *Config, depth int, path []any) error {
+	switch v := v.(type) {
+	case string:
+		if p.MaxStringLength > 0 && utf8.RuneCountInString(v) > p.MaxStringLength {
+			return bodyLimitError("String length", p.MaxStringLength, path)
+		}
+	case []any:
+		if p.MaxDepth > 0 && depth > p.MaxDepth {
+			return bodyLimitError("Nesting depth", p.MaxDepth, path)
+		}
+		if p.MaxArrayLength > 0 && len(v) > p.MaxArrayLength {
+			return bodyLimitError("Array length", p.MaxArrayLength, path)
+		}
+		for i, item := range v {
+			if err := checkBodyLimits(item, p, depth+1, append(path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if p.MaxDepth > 0 && depth > p.MaxDepth {
+			return bodyLimitError("Nesting depth", p.MaxDepth, path)
+		}
+		for k, item := range v {
+			if p.MaxStringLength > 0 && utf8.RuneCountInString(k) > p.MaxStringLength {
+				return bodyLimitError("Key length", p.MaxStringLength, path)
+			}
+			if err := checkBodyLimits(item, p, depth+1, append(path, k)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func bodyLimitError(what string, limit int, path []any) error {
+	return NewProblem(ErrBodyLimit,
+		what+" exceeds the limit of "+strconv.Itoa(limit)+" at "+formatPath(path))
+}
+
+// Formats a path of keys and indices as a jq path expression,
+// e.g. .a[0]."b c".
+func formatPath(path []any) string {
+	if len(path) == 0 {
+		return "."
+	}
+
+	s := ""
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			s += "[" + strconv.Itoa(p) + "]"
+		case string:
+			if isIdentifier(p) {
+				s += "." + p
+			} else {
+				quoted, _ := gojq.Marshal(p)
+				s += "." + string(quoted)
+			}
+		}
+	}
+
+	return s
+}
+
+// Reports whether s can be used as a jq field name without quoting.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Counts requests cut off by the result count, output size or time
+// limits.
+var outputLimitedCounter, _ = meter.Int64Counter("goque.output.limited",
+	instrument.WithDescription("Requests cut off by the result count, output size or time limits"))
+
+// Returns a *Problem for an output limit and records it in the
+// goque.output.limited metric, tagged with the limit that was hit.
+func OutputLimitError(limit string, code ErrorCode, message string) error {
+	outputLimitedCounter.Add(context.Background(), 1, attribute.String("limit", limit))
+	return NewProblem(code, message)
+}
+
+// Returns a lower bound of the compact JSON size of v in bytes. The
+// walk stops once the size passes limit, so a large result over the
+// output limit is rejected without walking or encoding all of it.
+func minJSONSize(v any, limit int) int {
+	n := 0
+
+	var walk func(v any) bool
+	walk = func(v any) bool {
+		switch v := v.(type) {
+		case nil, bool:
+			n += 4 // null, true, false
+		case string:
+			n += len(v) + 2
+		case []any:
+			n += 2
+			for i, item := range v {
+				if n > limit || !walk(item) {
+					return false
+				}
+				if i > 0 {
+					n++
+				}
+			}
+		case map[string]any:
+			n += 2
+			i := 0
+			for k, item := range v {
+				if n > limit || !walk(item) {
+					return false
+				}
+				if n += len(k) + 3; i > 0 {
+					n++
+				}
+				i++
+			}
+		default:
+			n++ // Numbers are at least one digit
+		}
+
+		return n <= limit
+	}
+
+	walk(v)
+	return n
+}
+
+var errOutputLimit = errors.New("output limit exceeded")
+
+// A buffer failing writes with errOutputLimit once it would hold more
+// than limit bytes, so encoders stop early.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errOutputLimit
+	}
+
+	return b.Buffer.Write(p)
+}
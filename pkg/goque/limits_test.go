@@ -0,0 +1,201 @@
+package goque
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBodyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   any
+		params  Config
+		wantErr string
+	}{
+		{
+			name:   "no limits",
+			input:  []any{[]any{[]any{"long string"}}},
+			params: Config{},
+		},
+		{
+			name:   "within limits",
+			input:  map[string]any{"a": []any{"xy", float64(1)}},
+			params: Config{MaxDepth: 2, MaxArrayLength: 2, MaxStringLength: 2},
+		},
+		{
+			name:    "too deep",
+			input:   map[string]any{"a": []any{[]any{}}},
+			params:  Config{MaxDepth: 2},
+			wantErr: "Nesting depth exceeds the limit of 2 at .a[0]",
+		},
+		{
+			name:    "array too long",
+			input:   map[string]any{"a b": []any{float64(1), float64(2), float64(3)}},
+			params:  Config{MaxArrayLength: 2},
+			wantErr: `Array length exceeds the limit of 2 at ."a b"`,
+		},
+		{
+			name:    "string too long",
+			input:   []any{"ok", "日本語"},
+			params:  Config{MaxStringLength: 2},
+			wantErr: "String length exceeds the limit of 2 at [1]",
+		},
+		{
+			name:    "key too long",
+			input:   map[string]any{"abc": nil},
+			params:  Config{MaxStringLength: 2},
+			wantErr: "Key length exceeds the limit of 2 at .",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBodyLimits(tt.input, &tt.params)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, fiber.StatusUnprocessableEntity, err.(*Problem).Status)
+		})
+	}
+}
+
+func TestGetFirstValueIterMaxResults(t *testing.T) {
+	code := _compileJQCode(t, "range(5) | null", nil)
+
+	out, err := GetFirstValueIter(code.Run(nil), 0)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+
+	out, err = GetFirstValueIter(code.Run(nil), 5)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+
+	_, err = GetFirstValueIter(code.Run(nil), 4)
+	assert.EqualError(t, err, "Filter emitted more than 4 results")
+	assert.Equal(t, fiber.StatusUnprocessableEntity, err.(*Problem).Status)
+
+	// Values after the first non-null one count too
+	code = _compileJQCode(t, "null, 1, 2, 3", nil)
+
+	out, err = GetFirstValueIter(code.Run(nil), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, out)
+
+	_, err = GetFirstValueIter(code.Run(nil), 3)
+	assert.EqualError(t, err, "Filter emitted more than 3 results")
+
+	// Errors after it are not reported
+	code = _compileJQCode(t, `1, error("x")`, nil)
+
+	out, err = GetFirstValueIter(code.Run(nil), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, out)
+}
+
+// Records the bytes written and fails past limit like limitedBuffer.
+type _countingWriter struct {
+	written int
+	limit   int
+}
+
+func (w *_countingWriter) Write(p []byte) (int, error) {
+	if w.written += len(p); w.written > w.limit {
+		return 0, errOutputLimit
+	}
+	return len(p), nil
+}
+
+func TestWriteJSONStopsAtLimit(t *testing.T) {
+	e := _newEngine(t, nil)
+
+	big := make([]any, 1e5)
+	for i := range big {
+		big[i] = map[string]any{"pineapple": i}
+	}
+
+	w := &_countingWriter{limit: 100}
+	err := e.writeJSON(w, big, OutputFormat{Indent: "  "})
+	assert.ErrorIs(t, err, errOutputLimit)
+	assert.Less(t, w.written, 2*jsonFlushSize)
+}
+
+func TestMinJSONSize(t *testing.T) {
+	for _, v := range []any{
+		nil, true, false, "a\"b", 7, 1.5,
+		[]any{}, []any{1, "x", nil},
+		map[string]any{}, map[string]any{"a": 1, "bc": []any{true}},
+	} {
+		raw, err := JSONAPI(false, false).Marshal(v)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, minJSONSize(v, 1000), len(raw), "%v", v)
+	}
+
+	// The walk stops right after the limit
+	big := make([]any, 1e6)
+	n := minJSONSize(big, 100)
+	assert.Greater(t, n, 100)
+	assert.LessOrEqual(t, n, 105)
+}
+
+func TestHandlerOutputLimitFailsFast(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		accept  string
+		timeout time.Duration
+		resCode int
+		resBody string
+	}{
+		{
+			"evaluation",
+			"[range(1e8)]",
+			"application/json",
+			100 * time.Millisecond,
+			fiber.StatusUnprocessableEntity,
+			`{"type":"urn:goque:problem:time_limit_exceeded","title":"Time limit exceeded","status":422,"detail":"Filter evaluation exceeded the time limit","code":"time_limit_exceeded"}`,
+		},
+		{
+			"json encoding",
+			"[range(2e5)]",
+			"application/json",
+			0,
+			fiber.StatusInsufficientStorage,
+			`{"type":"urn:goque:problem:output_limit_exceeded","title":"Output limit exceeded","status":507,"detail":"Result exceeds the output limit of 100 bytes","code":"output_limit_exceeded"}`,
+		},
+		{
+			"xml encoding",
+			"[range(2e5)]",
+			"application/xml",
+			0,
+			fiber.StatusInsufficientStorage,
+			`{"type":"urn:goque:problem:output_limit_exceeded","title":"Output limit exceeded","status":507,"detail":"Result exceeds the output limit of 100 bytes","code":"output_limit_exceeded"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := _newEngine(t, func(c *Config) {
+				c.MaxOutputSize = 100
+				c.EvalTimeout = tt.timeout
+			})
+			c := _GetNewFiberContext()
+
+			c.Context().Request.SetBody([]byte(`null`))
+			c.Context().Request.Header.Add("content-type", "application/json")
+			c.Context().Request.Header.Add("accept", tt.accept)
+			c.Context().Request.Header.Add("x-goque-jq-filter", tt.filter)
+
+			start := time.Now()
+			assert.NoError(t, e.Handler()(c))
+			assert.Less(t, time.Since(start), 2*time.Second)
+
+			assert.Equal(t, tt.resCode, c.Response().StatusCode())
+			assert.JSONEq(t, tt.resBody, string(c.Response().Body()))
+		})
+	}
+}
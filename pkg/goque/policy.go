@@ -0,0 +1,323 @@
+package goque
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Policy for filters sent with the x-goque-jq-filter header or picked
+// by name with x-goque-filter-name.
+type FilterPolicy string
+
+const (
+	FilterPolicyAllow     FilterPolicy = "allow"     // Any header filter may run
+	FilterPolicyDeny      FilterPolicy = "deny"      // Header filters are rejected
+	FilterPolicyAllowlist FilterPolicy = "allowlist" // Only allowlisted hashes and names may run
+)
+
+// The policy of requests to one route, see Config.RoutePolicies.
+// Unset fields keep the Config fields of the same name.
+type RoutePolicy struct {
+	FilterPolicy FilterPolicy    // Policy for header filters, empty keeps Config.FilterPolicy
+	FuncAllow    map[string]bool // Functions filters may call, nil keeps Config.FuncAllow
+	FuncDeny     map[string]bool // Functions filters may not call, nil keeps Config.FuncDeny
+	Input        *InputMode      // Default input mode, nil keeps Config.Slurp and Config.NullInput
+}
+
+// The file extension of named filters, see LoadNamedFilters.
+const NamedFilterExt = ".jq"
+
+// Parses a filter policy name, case insensitive.
+func ParseFilterPolicy(s string) (FilterPolicy, bool) {
+	switch policy := FilterPolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case FilterPolicyAllow, FilterPolicyDeny, FilterPolicyAllowlist:
+		return policy, true
+	}
+
+	return "", false
+}
+
+// Parses a comma separated list of filter hashes and names into a
+// set. Hashes are lowercased, names are case sensitive. Entries that
+// are neither are skipped with a warning. Returns nil for an empty
+// list.
+func ParseFilterAllowlist(s string) map[string]bool {
+	var allowlist map[string]bool
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !IsFilterAllowlistEntry(entry) {
+			log.Warn().Str("entry", entry).Msg("Invalid GOQUE_FILTER_ALLOWLIST entry, expected a SHA-256 hex hash or filter name")
+			continue
+		}
+		if IsFilterHash(entry) {
+			entry = strings.ToLower(entry)
+		}
+		if allowlist == nil {
+			allowlist = make(map[string]bool)
+		}
+		allowlist[entry] = true
+	}
+
+	return allowlist
+}
+
+// Reports whether s is a filter hash or a filter name.
+func IsFilterAllowlistEntry(s string) bool {
+	return IsFilterHash(s) || IsFilterName(s)
+}
+
+// Reports whether s is a hex encoded SHA-256 hash.
+func IsFilterHash(s string) bool {
+	b, err := hex.DecodeString(strings.ToLower(s))
+	return err == nil && len(b) == sha256.Size
+}
+
+// Reports whether s can name a filter: letters, digits, '_', '-' and
+// '.', not starting with '.'. Names that read as a hash are hashes.
+func IsFilterName(s string) bool {
+	if s == "" || s[0] == '.' || IsFilterHash(s) {
+		return false
+	}
+
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads the named filters of dir: each name.jq file is a filter
+// clients may run with x-goque-filter-name: name. Other files are
+// ignored. Returns nil for an empty dir.
+func LoadNamedFilters(dir string) (map[string]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+NamedFilterExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var filters map[string]string
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), NamedFilterExt)
+		if !IsFilterName(name) {
+			log.Warn().Str("file", file).Msg("Skipping named filter, the file name is not a valid filter name")
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if filters == nil {
+			filters = make(map[string]string)
+		}
+		filters[name] = string(data)
+	}
+
+	return filters, nil
+}
+
+// Returns the names of the named filters of p, sorted.
+func NamedFilterNames(p *Config) []string {
+	names := make([]string, 0, len(p.NamedFilters))
+	for name := range p.NamedFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parses a comma separated list of route path to filter policy
+// mappings, e.g. "/api/v1/jq:deny,/internal/jq:allow", into route
+// policies setting FilterPolicy. Invalid entries are skipped with a
+// warning. Returns nil for an empty list.
+func ParseRoutePolicies(s string) map[string]RoutePolicy {
+	var policies map[string]RoutePolicy
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		path, policy, ok := ParseRoutePolicyEntry(entry)
+		if !ok {
+			log.Warn().Str("entry", entry).Msg("Invalid route policy entry, expected path:policy")
+			continue
+		}
+
+		if policies == nil {
+			policies = make(map[string]RoutePolicy)
+		}
+		policies[path] = RoutePolicy{FilterPolicy: policy}
+	}
+
+	return policies
+}
+
+// Parses lists by route path, e.g. "/public:input,inputs,/internal:":
+// an entry starting with '/' is a path, a colon and the first item of
+// its list, the entries up to the next path are the other items. A
+// path without items gets an empty list. Items that are not valid and
+// items before the first path are skipped with a warning naming what.
+// Returns nil for an empty list.
+func parseRouteLists(s string, valid func(string) bool, what string) map[string][]string {
+	var lists map[string][]string
+	path := ""
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		item := entry
+		if strings.HasPrefix(entry, "/") {
+			var ok bool
+			if path, item, ok = strings.Cut(entry, ":"); !ok {
+				log.Warn().Str("entry", entry).Msg("Invalid route list entry, expected path:" + what)
+				path = ""
+				continue
+			}
+
+			if lists == nil {
+				lists = make(map[string][]string)
+			}
+			if _, ok := lists[path]; !ok {
+				lists[path] = []string{}
+			}
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+		}
+
+		if path == "" || !valid(item) {
+			log.Warn().Str("entry", entry).Msg("Invalid route list entry, expected path:" + what)
+			continue
+		}
+		lists[path] = append(lists[path], item)
+	}
+
+	return lists
+}
+
+// Reports whether entry is valid in a list by route path, see
+// parseRouteLists: a path:item entry or an item, both checked with
+// valid. A path without items is valid.
+func isRouteListEntry(entry string, valid func(string) bool) bool {
+	if !strings.HasPrefix(entry, "/") {
+		return valid(entry)
+	}
+
+	_, item, ok := strings.Cut(entry, ":")
+	item = strings.TrimSpace(item)
+	return ok && (item == "" || valid(item))
+}
+
+// Parses a path:policy entry of a route policy list. The path must
+// start with '/'.
+func ParseRoutePolicyEntry(entry string) (string, FilterPolicy, bool) {
+	i := strings.LastIndex(entry, ":")
+	if i < 0 {
+		return "", "", false
+	}
+
+	path := strings.TrimSpace(entry[:i])
+	policy, ok := ParseFilterPolicy(entry[i+1:])
+	if !ok || !strings.HasPrefix(path, "/") {
+		return "", "", false
+	}
+
+	return path, policy, true
+}
+
+// Returns the configuration of requests to path: p with the fields
+// set by the route policy of path, without route policies.
+func (p Config) RouteConfig(path string) Config {
+	policy := p.RoutePolicies[path]
+	p.RoutePolicies = nil
+
+	if policy.FilterPolicy != "" {
+		p.FilterPolicy = policy.FilterPolicy
+	}
+	if policy.FuncAllow != nil {
+		p.FuncAllow = policy.FuncAllow
+	}
+	if policy.FuncDeny != nil {
+		p.FuncDeny = policy.FuncDeny
+	}
+	if policy.Input != nil {
+		p.Slurp, p.NullInput = policy.Input.Slurp, policy.Input.NullInput
+	}
+
+	return p
+}
+
+// Returns the filter policy of requests to path, see RouteConfig.
+func (p Config) RouteFilterPolicy(path string) FilterPolicy {
+	return p.RouteConfig(path).FilterPolicy
+}
+
+// Returns the hex encoded SHA-256 of a filter, as used by the
+// allowlist. Equivalent to `printf '%s' "$filter" | sha256sum`.
+func FilterHash(filter string) string {
+	sum := sha256.Sum256([]byte(filter))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks a header filter against the filter policy of p, the
+// configuration of the request path. name is the filter name for
+// x-goque-filter-name requests, the allowlist accepts the filter by
+// its hash or name. Rejected filters are audit logged and returned as
+// a 403 *Problem.
+func checkHeaderFilter(x exchange, p *Config, filter string, name string) error {
+	var reason string
+
+	policy := p.FilterPolicy
+	switch policy {
+	case FilterPolicyDeny:
+		reason = "Header filters are not allowed"
+	case FilterPolicyAllowlist:
+		if !p.FilterAllowlist[FilterHash(filter)] && (name == "" || !p.FilterAllowlist[name]) {
+			reason = "Header filter is not allowlisted"
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	log.Warn().
+		Str("audit", "header_filter_rejected").
+		Str("policy", string(policy)).
+		Str("filterName", name).
+		Str("filterHash", FilterHash(filter)).
+		Str("ip", x.IP()).
+		Str("path", x.Path()).
+		Str("userAgent", x.Get(fiber.HeaderUserAgent)).
+		Msg(reason)
+
+	return NewProblem(ErrFilterForbidden, reason)
+}
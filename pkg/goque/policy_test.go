@@ -0,0 +1,204 @@
+package goque
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilterPolicy(t *testing.T) {
+	for _, s := range []string{"allow", "DENY", " allowlist "} {
+		_, ok := ParseFilterPolicy(s)
+		assert.True(t, ok, s)
+	}
+
+	_, ok := ParseFilterPolicy("maybe")
+	assert.False(t, ok)
+}
+
+func TestParseFilterAllowlist(t *testing.T) {
+	assert.Nil(t, ParseFilterAllowlist(""))
+	assert.Nil(t, ParseFilterAllowlist("not a hash"))
+
+	hash := FilterHash(".test")
+	assert.Equal(t, "5559ec61ae317cdf207a17666b01777b00ddf4ab1044be5cc213dd3618e5f98c", hash)
+	assert.Equal(t, map[string]bool{hash: true}, ParseFilterAllowlist(" "+hash+", bad name,"))
+
+	// Hashes are lowercased, names kept
+	assert.Equal(t, map[string]bool{hash: true, "Top-Users": true}, ParseFilterAllowlist(strings.ToUpper(hash)+",Top-Users,.test"))
+}
+
+func TestIsFilterName(t *testing.T) {
+	for _, s := range []string{"a", "top-users", "v1.2_x"} {
+		assert.True(t, IsFilterName(s), s)
+	}
+	for _, s := range []string{"", ".hidden", "a b", "a/b", "a:b", FilterHash(".")} {
+		assert.False(t, IsFilterName(s), s)
+	}
+}
+
+func TestParseRoutePolicies(t *testing.T) {
+	assert.Nil(t, ParseRoutePolicies(""))
+	assert.Equal(t,
+		map[string]RoutePolicy{"/api/v1/jq": {FilterPolicy: FilterPolicyDeny}, "/api/v1/jq/validate": {FilterPolicy: FilterPolicyAllow}},
+		ParseRoutePolicies("/api/v1/jq:DENY, /api/v1/jq/validate:allow, api:allow, /jq:maybe, /jq"))
+}
+
+func TestLoadNamedFilters(t *testing.T) {
+	filters, err := LoadNamedFilters("")
+	assert.NoError(t, err)
+	assert.Nil(t, filters)
+
+	_, err = LoadNamedFilters(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	for file, filter := range map[string]string{"peanuts.jq": ".peanuts", "a b.jq": ".", "notes.txt": "."} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte(filter), 0o600))
+	}
+
+	filters, err = LoadNamedFilters(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"peanuts": ".peanuts"}, filters)
+}
+
+func TestNewEngineNamedFilters(t *testing.T) {
+	config := DefaultConfig()
+	config.NamedFilters = map[string]string{"ok": ".", "broken": "(wut"}
+
+	_, err := NewEngine(config)
+	assert.EqualError(t, err, "named filter broken: unexpected EOF")
+}
+
+func TestHandlerFilterPolicy(t *testing.T) {
+	allowed := ".peanuts"
+
+	tests := []struct {
+		name    string
+		policy  FilterPolicy
+		filter  string
+		resCode int
+		resBody string
+	}{
+		{"allow", FilterPolicyAllow, ".pineapple", fiber.StatusOK, `"nope."`},
+		{"deny", FilterPolicyDeny, ".pineapple", fiber.StatusForbidden, `{"type":"urn:goque:problem:filter_forbidden","title":"Filter forbidden","status":403,"detail":"Header filters are not allowed","code":"filter_forbidden"}`},
+		{"allowlisted", FilterPolicyAllowlist, allowed, fiber.StatusOK, `true`},
+		{"not allowlisted", FilterPolicyAllowlist, ".pineapple", fiber.StatusForbidden, `{"type":"urn:goque:problem:filter_forbidden","title":"Filter forbidden","status":403,"detail":"Header filter is not allowlisted","code":"filter_forbidden"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := _newEngine(t, func(c *Config) {
+				c.FilterPolicy = tt.policy
+				c.FilterAllowlist = map[string]bool{FilterHash(allowed): true}
+			})
+			c := _GetNewFiberContext()
+
+			c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
+			c.Context().Request.Header.Add("content-type", "application/json")
+			c.Context().Request.Header.Add("x-goque-jq-filter", tt.filter)
+
+			assert.NoError(t, e.Handler()(c))
+			assert.Equal(t, tt.resCode, c.Response().StatusCode())
+			assert.JSONEq(t, tt.resBody, string(c.Response().Body()))
+		})
+	}
+
+	// Percent-encoded filters are allowlisted by the hash of the filter
+	// as written
+	multiLine := "# peanuts\n.peanuts"
+	e := _newEngine(t, func(c *Config) {
+		c.FilterPolicy = FilterPolicyAllowlist
+		c.FilterAllowlist = map[string]bool{FilterHash(multiLine): true}
+	})
+	c := _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("x-goque-jq-filter", url.PathEscape(multiLine))
+	c.Context().Request.Header.Add("x-goque-jq-filter-encoding", "percent")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+	assert.Equal(t, `true`, string(c.Response().Body()))
+
+	// The compiled filter still runs when header filters are denied
+	e = _newEngine(t, func(c *Config) {
+		c.FilterPolicy = FilterPolicyDeny
+		c.Filter = ".pineapple"
+	})
+	c = _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+	assert.Equal(t, `"nope."`, string(c.Response().Body()))
+}
+
+func TestHandlerNamedFilterPolicy(t *testing.T) {
+	forbidden := `{"type":"urn:goque:problem:filter_forbidden","title":"Filter forbidden","status":403,"detail":"Header filter is not allowlisted","code":"filter_forbidden"}`
+
+	tests := []struct {
+		name    string
+		policy  FilterPolicy
+		headers map[string]string
+		resCode int
+		resBody string
+	}{
+		{"allow by name", FilterPolicyAllow, map[string]string{"x-goque-filter-name": "pineapple"}, fiber.StatusOK, `"nope."`},
+		{"deny by name", FilterPolicyDeny, map[string]string{"x-goque-filter-name": "peanuts"}, fiber.StatusForbidden, `{"type":"urn:goque:problem:filter_forbidden","title":"Filter forbidden","status":403,"detail":"Header filters are not allowed","code":"filter_forbidden"}`},
+		{"allowlisted name", FilterPolicyAllowlist, map[string]string{"x-goque-filter-name": "peanuts"}, fiber.StatusOK, `true`},
+		{"not allowlisted name", FilterPolicyAllowlist, map[string]string{"x-goque-filter-name": "pineapple"}, fiber.StatusForbidden, forbidden},
+		// The name allowlists the named filter only, not its text
+		{"allowlisted name as text", FilterPolicyAllowlist, map[string]string{"x-goque-jq-filter": ".peanuts"}, fiber.StatusForbidden, forbidden},
+		{"unknown name", FilterPolicyAllow, map[string]string{"x-goque-filter-name": "coconut"}, fiber.StatusNotFound, `{"type":"urn:goque:problem:not_found","title":"Not found","status":404,"detail":"Unknown filter name: coconut","code":"not_found"}`},
+		{"name and filter", FilterPolicyAllow, map[string]string{"x-goque-filter-name": "peanuts", "x-goque-jq-filter": "."}, fiber.StatusBadRequest, `{"type":"urn:goque:problem:invalid_request","title":"Invalid request","status":400,"detail":"Send either x-goque-jq-filter or x-goque-filter-name","code":"invalid_request"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := _newEngine(t, func(c *Config) {
+				c.FilterPolicy = tt.policy
+				c.FilterAllowlist = map[string]bool{"peanuts": true}
+				c.NamedFilters = map[string]string{"peanuts": ".peanuts", "pineapple": ".pineapple"}
+			})
+			c := _GetNewFiberContext()
+
+			c.Context().Request.SetBody([]byte(`{"peanuts":true,"pineapple":"nope."}`))
+			c.Context().Request.Header.Add("content-type", "application/json")
+			for k, v := range tt.headers {
+				c.Context().Request.Header.Add(k, v)
+			}
+
+			assert.NoError(t, e.Handler()(c))
+			assert.Equal(t, tt.resCode, c.Response().StatusCode())
+			assert.JSONEq(t, tt.resBody, string(c.Response().Body()))
+		})
+	}
+}
+
+func TestHandlerRoutePolicy(t *testing.T) {
+	e := _newEngine(t, func(c *Config) {
+		c.FilterPolicy = FilterPolicyAllow
+		c.RoutePolicies = map[string]RoutePolicy{"/public/jq": {FilterPolicy: FilterPolicyDeny}}
+	})
+
+	for path, status := range map[string]int{"/public/jq": fiber.StatusForbidden, "/internal/jq": fiber.StatusOK} {
+		c := _GetNewFiberContext()
+		c.Path(path)
+
+		c.Context().Request.SetBody([]byte(`{"peanuts":true}`))
+		c.Context().Request.Header.Add("content-type", "application/json")
+		c.Context().Request.Header.Add("x-goque-jq-filter", ".peanuts")
+
+		assert.NoError(t, e.Handler()(c))
+		assert.Equal(t, status, c.Response().StatusCode(), path)
+	}
+}
@@ -0,0 +1,42 @@
+package goque
+
+import (
+	"os"
+	"strings"
+)
+
+// Parses a comma separated list of env var prefixes. Returns nil for
+// an empty list.
+func ParseEnvPrefixes(s string) []string {
+	var prefixes []string
+
+	for _, prefix := range strings.Split(s, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// Returns an environ loader for gojq.WithEnvironLoader exposing only
+// the env vars whose names start with one of prefixes. Without
+// prefixes $ENV and env are empty objects, so filters sent by clients
+// cannot read secrets from the container environment.
+func SandboxedEnviron(prefixes []string) func() []string {
+	return func() []string {
+		env := []string{}
+
+		for _, kv := range os.Environ() {
+			name, _, _ := strings.Cut(kv, "=")
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(name, prefix) {
+					env = append(env, kv)
+					break
+				}
+			}
+		}
+
+		return env
+	}
+}
@@ -0,0 +1,81 @@
+package goque
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _setEnvFromEnviron(environ []string) {
+	os.Clearenv()
+	for _, v := range environ {
+		split := strings.Split(v, "=")
+		os.Setenv(split[0], split[1])
+	}
+}
+
+func TestParseEnvPrefixes(t *testing.T) {
+	assert.Nil(t, ParseEnvPrefixes(""))
+	assert.Nil(t, ParseEnvPrefixes(" , "))
+	assert.Equal(t, []string{"APP_", "PUBLIC_"}, ParseEnvPrefixes("APP_, PUBLIC_"))
+}
+
+func TestSandboxedEnviron(t *testing.T) {
+	environ := os.Environ()
+	defer _setEnvFromEnviron(environ)
+
+	os.Clearenv()
+	os.Setenv("GOQUE_TRACER_ENDPOINT", "http://secret")
+	os.Setenv("DB_PASSWORD", "hunter2")
+	os.Setenv("PUBLIC_REGION", "eu")
+
+	tests := []struct {
+		name     string
+		prefixes []string
+		filter   string
+		want     any
+	}{
+		{"$ENV empty by default", nil, "$ENV", map[string]any{}},
+		{"env empty by default", nil, "env", map[string]any{}},
+		{"no secret by name", nil, "$ENV.DB_PASSWORD", nil},
+		{"prefix allowlisted", []string{"PUBLIC_"}, "$ENV", map[string]any{"PUBLIC_REGION": "eu"}},
+		{"prefix allowlisted env", []string{"PUBLIC_"}, "env | keys", []any{"PUBLIC_REGION"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := _compileJQCode(t, tt.filter, func(c *Config) { c.EnvPrefixes = tt.prefixes })
+
+			out, ok := code.Run(nil).Next()
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestHandlerEnvLeak(t *testing.T) {
+	environ := os.Environ()
+	defer _setEnvFromEnviron(environ)
+
+	os.Setenv("DB_PASSWORD", "hunter2")
+
+	e := _newEngine(t, nil)
+
+	for _, filter := range []string{"$ENV", "env", "[$ENV[], env[]] | length"} {
+		c := _GetNewFiberContext()
+
+		c.Context().Request.SetBody([]byte(`{}`))
+		c.Context().Request.Header.Add("content-type", "application/json")
+		c.Context().Request.Header.Add("x-goque-jq-filter", filter)
+
+		assert.NoError(t, e.Handler()(c))
+		assert.NotContains(t, string(c.Response().Body()), "hunter2")
+	}
+
+	// The compiled filter is sandboxed as well
+	e = _newEngine(t, func(c *Config) { c.Filter = "$ENV" })
+	out, _ := GetFirstValueIter(e.code.Run(nil), 0)
+	assert.Equal(t, map[string]any{}, out)
+}
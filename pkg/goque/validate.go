@@ -0,0 +1,253 @@
+package goque
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/itchyny/gojq"
+)
+
+// A parse or compile error in a filter, located in the filter text
+// where possible.
+type FilterError struct {
+	Code    ErrorCode // ErrFilterParse, ErrFuncNotAllowed or ErrFilterCompile
+	Message string    // The gojq error message
+	Offset  int       // Byte offset of the error in the filter, -1 if unknown
+	Line    int       // 1-based line of the error, 0 if unknown
+	Column  int       // 1-based column of the error in runes, 0 if unknown
+}
+
+func (e *FilterError) Error() string {
+	return e.Message
+}
+
+// Returns the error payload fields, omitting an unknown location.
+func (e *FilterError) fields() fiber.Map {
+	m := fiber.Map{"code": e.Code, "message": e.Message}
+	if e.Offset >= 0 {
+		m["offset"] = e.Offset
+		m["line"] = e.Line
+		m["column"] = e.Column
+	}
+	return m
+}
+
+// Wraps a parse, function policy or compile error for the filter src
+// into a *FilterError with its location. Parse errors are located by
+// their token, undefined functions and variables by their first use.
+func NewFilterError(src string, err error) *FilterError {
+	e := &FilterError{Code: filterErrorCode(err), Message: err.Error(), Offset: filterErrorOffset(src, err)}
+	if e.Offset >= 0 {
+		e.Line, e.Column = lineColumn(src, e.Offset)
+	}
+	return e
+}
+
+func filterErrorCode(err error) ErrorCode {
+	var policyErr *FuncPolicyError
+	if errors.As(err, &policyErr) {
+		return ErrFuncNotAllowed
+	}
+
+	if _, ok := err.(interface{ Token() (string, int) }); ok {
+		return ErrFilterParse
+	}
+
+	return ErrFilterCompile
+}
+
+func filterErrorOffset(src string, err error) int {
+	var policyErr *FuncPolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Offset
+	}
+
+	// gojq parse errors report the offset after the offending token
+	if e, ok := err.(interface{ Token() (string, int) }); ok {
+		token, offset := e.Token()
+		offset -= len(token)
+		if offset < 0 {
+			offset = 0
+		} else if offset > len(src) {
+			offset = len(src)
+		}
+		return offset
+	}
+
+	msg := err.Error()
+	if name, ok := strings.CutPrefix(msg, "function not defined: "); ok {
+		name, arity, _ := strings.Cut(name, "/")
+		n, _ := strconv.Atoi(arity)
+		return findCallOffset(src, name, n)
+	}
+	if name, ok := strings.CutPrefix(msg, "variable not defined: "); ok {
+		return findCallOffset(src, name, 0)
+	}
+
+	return -1
+}
+
+// Parses, checks and compiles filter with the function policy of p
+// and options.
+func compileFilter(filter string, p *Config, options []gojq.CompilerOption) (*gojq.Query, *gojq.Code, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, nil, NewFilterError(filter, err)
+	}
+
+	if err := CheckFuncPolicy(query, filter, p); err != nil {
+		return nil, nil, NewFilterError(filter, err)
+	}
+
+	code, err := gojq.Compile(query, options...)
+	if err != nil {
+		return nil, nil, NewFilterError(filter, err)
+	}
+
+	return query, code, nil
+}
+
+// The body of a filter validation request.
+type validateRequest struct {
+	Filter string `json:"filter"`
+	AST    bool   `json:"ast"`    // Return the parsed filter as JSON
+	Format bool   `json:"format"` // Return the filter in canonical form
+}
+
+// Returns the handler for filter validation requests. The filter is
+// parsed, checked and compiled like a header filter but not run. Valid
+// filters return 200, optionally with the AST and the canonical
+// filter. Invalid filters return 422 with the located errors.
+// Malformed requests return a 400 problem, see HandleError.
+func (e *Engine) ValidateHandler() fiber.Handler {
+	return e.handleValidate
+}
+
+func (e *Engine) handleValidate(c *fiber.Ctx) error {
+	if route, ok := e.routes[c.Path()]; ok {
+		return route.handleValidate(c)
+	}
+
+	var req validateRequest
+	if err := e.json.Unmarshal(c.Request().Body(), &req); err != nil {
+		return e.HandleError(c, NewProblem(ErrInvalidBody, "Invalid validation request: "+err.Error()))
+	}
+
+	if req.Filter == "" {
+		return e.HandleError(c, NewProblem(ErrMissingFilter, "A JQ filter was not sent with request"))
+	}
+
+	query, _, err := e.Compile(req.Filter)
+	if err != nil {
+		c.Status(fiber.StatusUnprocessableEntity)
+		return e.sendJSON(c, fiber.Map{
+			"status":  "error",
+			"valid":   false,
+			"message": err.Error(),
+			"errors":  []fiber.Map{err.(*FilterError).fields()},
+		})
+	}
+
+	res := fiber.Map{"status": "ok", "valid": true}
+	if req.AST {
+		res["ast"] = ASTJSON(query)
+	}
+	if req.Format {
+		res["formatted"] = query.String()
+	}
+
+	return e.sendJSON(c, res)
+}
+
+// Sends v as application/json, escaping HTML per Config.EscapeHTML.
+func (e *Engine) sendJSON(c *fiber.Ctx, v any) error {
+	raw, err := JSONAPI(e.config.EscapeHTML, false).Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(raw)
+}
+
+// Converts a parsed filter to a JSON compatible value. Struct fields
+// become lowerCamelCase keys, empty fields are omitted, and operators
+// and term types are rendered by name, e.g. "|" and "identity".
+func ASTJSON(query *gojq.Query) any {
+	return astValue(reflect.ValueOf(query))
+}
+
+var (
+	operatorType = reflect.TypeOf(gojq.Operator(0))
+	termTypeType = reflect.TypeOf(gojq.TermType(0))
+)
+
+func astValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return astValue(v.Elem())
+	case reflect.Struct:
+		obj := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if fv := astValue(v.Field(i)); fv != nil {
+				obj[lowerFirst(v.Type().Field(i).Name)] = fv
+			}
+		}
+		if len(obj) == 0 {
+			return nil
+		}
+		return obj
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		arr := make([]any, v.Len())
+		for i := range arr {
+			arr[i] = astValue(v.Index(i))
+		}
+		return arr
+	case reflect.String:
+		if v.Len() == 0 {
+			return nil
+		}
+		return v.String()
+	case reflect.Bool:
+		if !v.Bool() {
+			return nil
+		}
+		return true
+	case reflect.Int:
+		if v.Int() == 0 {
+			return nil
+		}
+		switch v.Type() {
+		case operatorType:
+			return v.Interface().(gojq.Operator).String()
+		case termTypeType:
+			name := v.Interface().(gojq.TermType).GoString()
+			return lowerFirst(strings.TrimPrefix(name, "gojq.TermType"))
+		}
+		return int(v.Int())
+	}
+
+	return nil
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,48 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/itchyny/gojq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFilterError(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		offset int
+		line   int
+		column int
+	}{
+		{"unexpected token", ".a | ]", 5, 1, 6},
+		{"unexpected EOF", ".a |", 4, 1, 5},
+		{"multiline", ".a +\n  )", 7, 2, 3},
+		{"undefined function", `"nope." | pineapple`, 10, 1, 11},
+		{"undefined variable", `.peanuts | $pineapple`, 11, 1, 12},
+		{"undefined function after key", `{pineapple: 1} | pineapple`, 17, 1, 18},
+		{"undefined function after other arity", `def pineapple(f): f; pineapple`, 21, 1, 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := _newEngine(t, nil).Compile(tt.filter)
+			assert.Error(t, err)
+
+			e := err.(*FilterError)
+			assert.Equal(t, tt.offset, e.Offset)
+			assert.Equal(t, tt.line, e.Line)
+			assert.Equal(t, tt.column, e.Column)
+		})
+	}
+}
+
+func TestASTJSON(t *testing.T) {
+	query, _ := gojq.Parse(".peanuts | not")
+
+	assert.Equal(t, map[string]any{
+		"left":  map[string]any{"term": map[string]any{"type": "index", "index": map[string]any{"name": "peanuts"}}},
+		"op":    "|",
+		"right": map[string]any{"term": map[string]any{"type": "func", "func": map[string]any{"name": "not"}}},
+	}, ASTJSON(query))
+}
@@ -0,0 +1,334 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The element name used when an XML response cannot take its root
+// element name from the jq result (e.g. arrays or scalars).
+const xmlDefaultRoot = "result"
+
+// The element name used for array items that have no parent key.
+const xmlDefaultItem = "item"
+
+// XML documents are converted to JSON with the following conventions:
+//
+//   - The document becomes an object with a single key, the root element name
+//   - Attributes are stored as "@<name>" keys
+//   - Text content is stored as "#text", or as a plain string if the
+//     element has no attributes or child elements
+//   - Elements without attributes, children or text become null
+//   - Repeated child elements are collected into arrays in document order
+//
+// Element and attribute names keep their namespace prefix, e.g.
+// "soap:Body" and "@xmlns:soap", so EncodeXML writes them back as is.
+// Whitespace surrounding text content is trimmed. All text, including
+// numbers and booleans, is kept as strings. Documents with more than
+// one root element are rejected.
+func ParseXML(data []byte) (any, error) {
+	type frame struct {
+		name string
+		obj  map[string]any
+		text strings.Builder
+	}
+
+	root := &frame{obj: map[string]any{}}
+	stack := []*frame{root}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	// RawToken keeps the prefixes Token translates to namespace URLs,
+	// so end elements are matched here
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 1 && len(root.obj) != 0 {
+				return nil, errors.New("XML document has more than one root element")
+			}
+
+			f := &frame{name: xmlQName(t.Name), obj: map[string]any{}}
+			for _, a := range t.Attr {
+				f.obj["@"+xmlQName(a.Name)] = a.Value
+			}
+			stack = append(stack, f)
+		case xml.EndElement:
+			f := stack[len(stack)-1]
+			if name := xmlQName(t.Name); len(stack) == 1 || name != f.name {
+				return nil, errors.New("XML element </" + name + "> does not close an open element")
+			}
+			stack = stack[:len(stack)-1]
+
+			var value any
+			text := strings.TrimSpace(f.text.String())
+			switch {
+			case len(f.obj) == 0 && text == "":
+				value = nil
+			case len(f.obj) == 0:
+				value = text
+			default:
+				if text != "" {
+					f.obj["#text"] = text
+				}
+				value = f.obj
+			}
+
+			addXMLChild(stack[len(stack)-1].obj, f.name, value)
+		case xml.CharData:
+			stack[len(stack)-1].text.Write(t)
+		}
+	}
+
+	if len(stack) > 1 {
+		return nil, errors.New("XML element <" + stack[len(stack)-1].name + "> is not closed")
+	}
+
+	if len(root.obj) == 0 {
+		return nil, errors.New("XML document has no root element")
+	}
+
+	return root.obj, nil
+}
+
+// Returns the name of an element or attribute with its prefix, e.g.
+// "soap:Body".
+func xmlQName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// Adds a child element value to obj, turning repeated elements into
+// an array.
+func addXMLChild(obj map[string]any, name string, value any) {
+	existing, ok := obj[name]
+	if !ok {
+		obj[name] = value
+		return
+	}
+
+	if arr, ok := existing.([]any); ok {
+		obj[name] = append(arr, value)
+		return
+	}
+
+	obj[name] = []any{existing, value}
+}
+
+// Renders a jq result as XML using the inverse of the ParseXML
+// conventions. An object with a single element key is used as the
+// root element, unless its value is an array; any other value is
+// wrapped in a <result> element. Top-level arrays become a <result>
+// element with an <item> per value, so the document has one root.
+func EncodeXML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeXML(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Writes v to w as EncodeXML renders it.
+func writeXML(w io.Writer, v any) error {
+	enc := xml.NewEncoder(w)
+
+	var err error
+	if arr, ok := v.([]any); ok {
+		err = encodeXMLElement(enc, xmlDefaultRoot, map[string]any{xmlDefaultItem: arr})
+	} else if obj, ok := v.(map[string]any); ok && len(obj) == 1 {
+		for k, child := range obj {
+			if _, isArr := child.([]any); !isArr && !strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "#") {
+				err = encodeXMLElement(enc, k, child)
+			} else {
+				err = encodeXMLElement(enc, xmlDefaultRoot, v)
+			}
+		}
+	} else {
+		err = encodeXMLElement(enc, xmlDefaultRoot, v)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// Encodes a single named element. Arrays are encoded as repeated
+// elements of the same name. Keys that are not XML names return a 422
+// problem rather than malformed XML.
+func encodeXMLElement(enc *xml.Encoder, name string, v any) error {
+	if !isXMLName(name) {
+		return NewProblem(ErrXMLEncoding, "Key "+strconv.Quote(name)+" is not a valid XML element name")
+	}
+
+	if arr, ok := v.([]any); ok {
+		for _, item := range arr {
+			if _, nested := item.([]any); nested {
+				if err := encodeXMLElement(enc, name, map[string]any{xmlDefaultItem: item}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := encodeXMLElement(enc, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	obj, isObj := v.(map[string]any)
+	if !isObj {
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if v != nil {
+			text, err := xmlText(v)
+			if err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var children []string
+	for _, k := range keys {
+		if strings.HasPrefix(k, "@") {
+			text, err := xmlText(obj[k])
+			if err != nil {
+				return err
+			}
+			if !isXMLName(k[1:]) {
+				return NewProblem(ErrXMLEncoding, "Key "+strconv.Quote(k)+" is not a valid XML attribute name")
+			}
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: k[1:]}, Value: text})
+		} else if k != "#text" {
+			children = append(children, k)
+		}
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	if text, ok := obj["#text"]; ok && text != nil {
+		s, err := xmlText(text)
+		if err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(xml.CharData(s)); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range children {
+		if err := encodeXMLElement(enc, k, obj[k]); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// The ranges of NameStartChar in XML 1.0, without ":". NameChar adds
+// xmlNameChars.
+var xmlNameStartChars = [][2]rune{
+	{'A', 'Z'}, {'_', '_'}, {'a', 'z'}, {0xC0, 0xD6}, {0xD8, 0xF6},
+	{0xF8, 0x2FF}, {0x370, 0x37D}, {0x37F, 0x1FFF}, {0x200C, 0x200D},
+	{0x2070, 0x218F}, {0x2C00, 0x2FEF}, {0x3001, 0xD7FF},
+	{0xF900, 0xFDCF}, {0xFDF0, 0xFFFD}, {0x10000, 0xEFFFF},
+}
+
+var xmlNameChars = [][2]rune{
+	{'-', '-'}, {'.', '.'}, {'0', '9'}, {0xB7, 0xB7}, {0x300, 0x36F},
+	{0x203F, 0x2040},
+}
+
+func inRuneRanges(r rune, ranges [][2]rune) bool {
+	for _, rr := range ranges {
+		if r >= rr[0] && r <= rr[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether s is an XML name with an optional namespace prefix,
+// so it can be used as an element or attribute name, e.g. "a" or
+// "soap:Body", not "a b", "1x", "a:" or "".
+func isXMLName(s string) bool {
+	prefix, local, ok := strings.Cut(s, ":")
+	if ok {
+		return isXMLNCName(prefix) && isXMLNCName(local)
+	}
+	return isXMLNCName(s)
+}
+
+// Reports whether s is an XML name without a colon.
+func isXMLNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		if !inRuneRanges(r, xmlNameStartChars) && (i == 0 || !inRuneRanges(r, xmlNameChars)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the text representation of a scalar value. Strings are used
+// as is, other values are rendered as JSON.
+func xmlText(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Reports whether a Content-Type or Accept media type is XML, e.g.
+// application/xml, text/xml or application/soap+xml.
+func IsXMLMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml")
+}
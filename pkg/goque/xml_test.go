@@ -0,0 +1,246 @@
+package goque
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseXML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr bool
+	}{
+		{
+			name:  "text only element",
+			input: `<a>hi</a>`,
+			want:  map[string]any{"a": "hi"},
+		},
+		{
+			name:  "empty element",
+			input: `<a/>`,
+			want:  map[string]any{"a": nil},
+		},
+		{
+			name:  "attributes and text",
+			input: `<a id="1">hi</a>`,
+			want:  map[string]any{"a": map[string]any{"@id": "1", "#text": "hi"}},
+		},
+		{
+			name:  "repeated elements",
+			input: `<a><b>1</b><c/><b>2</b><b>3</b></a>`,
+			want:  map[string]any{"a": map[string]any{"b": []any{"1", "2", "3"}, "c": nil}},
+		},
+		{
+			name: "nested with whitespace and declaration",
+			input: `<?xml version="1.0"?>
+<envelope xmlns:soap="http://example.com/soap">
+	<body>
+		<item sku="x"/>
+	</body>
+</envelope>`,
+			want: map[string]any{"envelope": map[string]any{
+				"@xmlns:soap": "http://example.com/soap",
+				"body":        map[string]any{"item": map[string]any{"@sku": "x"}},
+			}},
+		},
+		{
+			name:  "prefixed names",
+			input: `<soap:Envelope xmlns:soap="urn:soap" xmlns:x="urn:x"><soap:Body x:y="1" y="2"><x:Body/></soap:Body></soap:Envelope>`,
+			want: map[string]any{"soap:Envelope": map[string]any{
+				"@xmlns:soap": "urn:soap",
+				"@xmlns:x":    "urn:x",
+				"soap:Body":   map[string]any{"@x:y": "1", "@y": "2", "x:Body": nil},
+			}},
+		},
+		{
+			name:    "several roots",
+			input:   `<a>1</a><b>2</b>`,
+			wantErr: true,
+		},
+		{
+			name:    "repeated root",
+			input:   `<a>1</a><a>2</a>`,
+			wantErr: true,
+		},
+		{
+			name:    "mismatched prefix",
+			input:   `<x:a></y:a>`,
+			wantErr: true,
+		},
+		{
+			name:    "unclosed",
+			input:   `<a><b></b>`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			input:   `<a><b></a>`,
+			wantErr: true,
+		},
+		{
+			name:    "no root",
+			input:   `   `,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseXML([]byte(tt.input))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input any
+		want  string
+	}{
+		{
+			name:  "single root",
+			input: map[string]any{"a": map[string]any{"@id": "1", "#text": "hi"}},
+			want:  `<a id="1">hi</a>`,
+		},
+		{
+			name:  "repeated elements and sorted keys",
+			input: map[string]any{"a": map[string]any{"c": nil, "b": []any{"1", float64(2), true}}},
+			want:  `<a><b>1</b><b>2</b><b>true</b><c></c></a>`,
+		},
+		{
+			name:  "scalar",
+			input: "x<y",
+			want:  `<result>x&lt;y</result>`,
+		},
+		{
+			name:  "array",
+			input: []any{float64(1), float64(2)},
+			want:  `<result><item>1</item><item>2</item></result>`,
+		},
+		{
+			name:  "empty array",
+			input: []any{},
+			want:  `<result></result>`,
+		},
+		{
+			name:  "single key array",
+			input: map[string]any{"a": []any{"1", "2"}},
+			want:  `<result><a>1</a><a>2</a></result>`,
+		},
+		{
+			name:  "prefixed names",
+			input: map[string]any{"soap:Envelope": map[string]any{"@xmlns:soap": "urn:soap", "soap:Body": "x"}},
+			want:  `<soap:Envelope xmlns:soap="urn:soap"><soap:Body>x</soap:Body></soap:Envelope>`,
+		},
+		{
+			name:  "multiple keys",
+			input: map[string]any{"a": "1", "b": "2"},
+			want:  `<result><a>1</a><b>2</b></result>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeXML(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestEncodeXMLInvalidNames(t *testing.T) {
+	for _, input := range []any{
+		map[string]any{"a b": float64(1)},
+		map[string]any{"1x": float64(1)},
+		map[string]any{"<": float64(1)},
+		map[string]any{"": float64(1)},
+		map[string]any{"a:": float64(1)},
+		map[string]any{":b": float64(1)},
+		map[string]any{"a:b:c": float64(1)},
+		map[string]any{"a": map[string]any{"@xmlns:": "1"}},
+		map[string]any{"a": map[string]any{"@b c": "1"}},
+		[]any{map[string]any{"ok": float64(1), "not ok": float64(2)}},
+	} {
+		_, err := EncodeXML(input)
+		assert.Error(t, err, input)
+		assert.Equal(t, ErrXMLEncoding, err.(*Problem).Code, input)
+	}
+
+	out, err := EncodeXML(map[string]any{"日本": map[string]any{"a-1.b_c": "ok"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `<日本><a-1.b_c>ok</a-1.b_c></日本>`, string(out))
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	input := `<order id="7"><line sku="a">2</line><line sku="b">3</line><note>rush</note></order>`
+
+	parsed, err := ParseXML([]byte(input))
+	assert.NoError(t, err)
+
+	out, err := EncodeXML(parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(out))
+
+	input = `<soap:Envelope xmlns:m="urn:orders" xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><m:order m:id="7">rush</m:order></soap:Body></soap:Envelope>`
+
+	parsed, err = ParseXML([]byte(input))
+	assert.NoError(t, err)
+
+	out, err = EncodeXML(parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(out))
+}
+
+func TestIsXMLMediaType(t *testing.T) {
+	assert.True(t, IsXMLMediaType("application/xml"))
+	assert.True(t, IsXMLMediaType("text/xml; charset=utf-8"))
+	assert.True(t, IsXMLMediaType("application/soap+xml"))
+	assert.False(t, IsXMLMediaType("application/json"))
+	assert.False(t, IsXMLMediaType(""))
+}
+
+func TestHandlerXML(t *testing.T) {
+	e, err := NewEngine(Config{})
+	assert.NoError(t, err)
+	c := _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`<test><peanuts>true</peanuts><pineapple>nope.</pineapple></test>`))
+	c.Context().Request.Header.Add("content-type", "application/xml")
+	c.Context().Request.Header.Add("x-goque-jq-filter", ".test.pineapple")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, `"nope."`, string(c.Response().Body()))
+	assert.Equal(t, fiber.StatusOK, c.Response().StatusCode())
+
+	c = _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"test":{"peanuts":true}}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("accept", "application/xml")
+	c.Context().Request.Header.Add("x-goque-jq-filter", ".")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, `<test><peanuts>true</peanuts></test>`, string(c.Response().Body()))
+	assert.Equal(t, "application/xml; charset=utf-8", string(c.Response().Header.ContentType()))
+
+	c = _GetNewFiberContext()
+
+	c.Context().Request.SetBody([]byte(`{"a b":1}`))
+	c.Context().Request.Header.Add("content-type", "application/json")
+	c.Context().Request.Header.Add("accept", "application/xml")
+	c.Context().Request.Header.Add("x-goque-jq-filter", ".")
+
+	assert.NoError(t, e.Handler()(c))
+	assert.Equal(t, fiber.StatusUnprocessableEntity, c.Response().StatusCode())
+	assert.JSONEq(t, `{"type":"urn:goque:problem:xml_encoding_error","title":"XML encoding error","status":422,"detail":"Key \"a b\" is not a valid XML element name","code":"xml_encoding_error"}`, string(c.Response().Body()))
+}